@@ -2,15 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	versioncollector "github.com/prometheus/client_golang/prometheus/collectors/version"
 	"github.com/prometheus/common/version"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -18,6 +19,12 @@ import (
 
 	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/collector"
 	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/enricher"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/fsys"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/otlp"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/remotewrite"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/textfile"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/web"
 )
 
 var (
@@ -31,13 +38,28 @@ designed for modern containerized environments and Kubernetes clusters.`,
 		Version: version.Version,
 		RunE:    run,
 	}
+	textfileCmd = &cobra.Command{
+		Use:   "textfile [path]",
+		Short: "Write metrics to a file for the textfile collector of node_exporter",
+		Long: `Write the metrics to a .prom file on an interval instead of serving them
+over HTTP, for hosts where node_exporter is the only listening exporter.
+The path defaults to --output.textfile.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: runTextfile,
+	}
+	// textfileOnce writes the textfile once and exits, e.g. from a timer
+	textfileOnce bool
 )
 
 func init() {
 	// Command line flags
 	rootCmd.PersistentFlags().String("web.listen-address", ":9753", "Address to listen on for web interface and telemetry")
 	rootCmd.PersistentFlags().String("web.telemetry-path", "/metrics", "Path under which to expose metrics")
+	rootCmd.PersistentFlags().String("web.config-file", "", "Path to a web configuration file enabling TLS and basic auth")
+	rootCmd.PersistentFlags().String("remote-write.url", "", "URL of a remote_write endpoint to push metrics to")
+	rootCmd.PersistentFlags().String("output.textfile", "", "Path of a .prom file to write metrics to instead of serving them")
 	rootCmd.PersistentFlags().String("cgroup.path", "/sys/fs/cgroup", "Path to cgroup v2 filesystem")
+	rootCmd.PersistentFlags().String("proc.path", "/proc", "Path to the proc filesystem")
 	rootCmd.PersistentFlags().StringSlice("collector.enable", []string{"cpu", "memory", "io", "pids"}, "Comma-separated list of enabled collectors")
 	rootCmd.PersistentFlags().StringSlice("collector.disable", []string{}, "Comma-separated list of disabled collectors")
 	rootCmd.PersistentFlags().String("log.level", "info", "Log level (debug, info, warn, error)")
@@ -49,8 +71,13 @@ func init() {
 
 	// Bind flags to viper
 	viper.BindPFlags(rootCmd.PersistentFlags())
+	viper.BindPFlag("web.config_file", rootCmd.PersistentFlags().Lookup("web.config-file"))
+	viper.BindPFlag("remote_write.url", rootCmd.PersistentFlags().Lookup("remote-write.url"))
 	viper.SetEnvPrefix("CGROUPV2_EXPORTER")
 	viper.AutomaticEnv()
+
+	textfileCmd.Flags().BoolVar(&textfileOnce, "once", false, "Write the textfile once and exit")
+	rootCmd.AddCommand(textfileCmd)
 }
 
 func main() {
@@ -59,6 +86,16 @@ func main() {
 	}
 }
 
+func runTextfile(cmd *cobra.Command, args []string) error {
+	if len(args) == 1 {
+		viper.Set("output.textfile", args[0])
+	}
+	if viper.GetString("output.textfile") == "" {
+		return fmt.Errorf("textfile requires a path argument or --output.textfile")
+	}
+	return run(cmd, args)
+}
+
 func run(cmd *cobra.Command, args []string) error {
 	// Initialize configuration
 	var err error
@@ -83,56 +120,55 @@ func run(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("cgroup v2 validation failed: %w", err)
 	}
 
-	// Create Prometheus registry
-	registry := prometheus.NewRegistry()
-
-	// Register version metrics
-	registry.MustRegister(version.NewCollector("prometheus_cgroup_v2_exporter"))
+	// Initialize enrichers
+	enrichers, err := enricher.New(cfg, log)
+	if err != nil {
+		return fmt.Errorf("failed to initialize enrichers: %w", err)
+	}
 
 	// Initialize collectors
-	collectors, err := collector.NewCollectors(cfg, log)
+	sources := collector.HostSources(cfg)
+	sources.Enrichers = enrichers
+	sources.Scanner = collector.NewScanner(cfg, sources, log)
+	collectors, err := collector.NewCollectorsWithSources(cfg, sources, log)
 	if err != nil {
 		return fmt.Errorf("failed to initialize collectors: %w", err)
 	}
 
-	// Register collectors
-	for name, coll := range collectors {
+	// Register collectors, along with the version metrics
+	for name := range collectors {
 		log.WithField("collector", name).Info("Registering collector")
-		registry.MustRegister(coll)
+	}
+	metricsHandler, err := web.NewMetricsHandler(cfg, sources.Scanner, collectors, log,
+		versioncollector.NewCollector("prometheus_cgroup_v2_exporter"))
+	if err != nil {
+		return fmt.Errorf("failed to register collectors: %w", err)
 	}
 
 	// Setup HTTP server
 	mux := http.NewServeMux()
-	mux.Handle(cfg.Web.TelemetryPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{
-		ErrorLog:      log,
-		ErrorHandling: promhttp.ContinueOnError,
-	}))
+	if !cfg.Web.DisableMetricsEndpoint {
+		mux.Handle(cfg.Web.TelemetryPath, metricsHandler)
+	}
+	mux.Handle("/probe", web.NewProbeHandler(cfg, sources, log))
+	mux.Handle(web.APIPrefix, web.NewAPIHandler(sources, log))
 
 	// Health check endpoint
-	mux.HandleFunc("/health", healthHandler)
-	mux.HandleFunc("/ready", readyHandler)
-
-	// Root handler with basic info
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprintf(w, `<html>
-<head><title>Prometheus cgroup v2 Exporter</title></head>
-<body>
-<h1>Prometheus cgroup v2 Exporter</h1>
-<p><a href="%s">Metrics</a></p>
-<p><a href="/health">Health</a></p>
-<p><a href="/ready">Ready</a></p>
-<p>Version: %s</p>
-</body>
-</html>`, cfg.Web.TelemetryPath, version.Version)
-	})
-
-	server := &http.Server{
+	mux.Handle("/health", web.NewHealthHandler(collectors, log))
+	mux.Handle("/ready", web.NewReadyHandler(sources, log))
+
+	// Status page
+	mux.Handle("/", web.NewLandingPage(cfg, collectors, sources, log))
+
+	server, err := web.NewServer(&http.Server{
 		Addr:         cfg.Web.ListenAddress,
 		Handler:      mux,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
+	}, cfg.Web.ConfigFile, log)
+	if err != nil {
+		return fmt.Errorf("failed to load web configuration: %w", err)
 	}
 
 	// Setup graceful shutdown
@@ -156,6 +192,14 @@ func run(cmd *cobra.Command, args []string) error {
 		}
 	}()
 
+	// Scan once at startup, so that the exporter becomes ready before the
+	// first scrape
+	go func() {
+		if _, err := sources.Scanner.Scan(ctx); err != nil {
+			log.WithError(err).Error("Initial cgroup scan failed")
+		}
+	}()
+
 	// Start collectors
 	for name, coll := range collectors {
 		if starter, ok := coll.(interface{ Start(context.Context) error }); ok {
@@ -167,8 +211,58 @@ func run(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	// Start enrichers that maintain metadata caches
+	for _, e := range enrichers {
+		if starter, ok := e.(interface{ Start(context.Context) error }); ok {
+			go func(name string, starter interface{ Start(context.Context) error }) {
+				if err := starter.Start(ctx); err != nil {
+					log.WithField("enricher", name).WithError(err).Error("Enricher failed")
+				}
+			}(e.Name(), starter)
+		}
+	}
+
+	// Push the metrics if remote_write is configured
+	if cfg.RemoteWrite.URL != "" {
+		pusher := remotewrite.NewPusher(cfg.RemoteWrite, metricsHandler.Gatherer(), log)
+		go func() {
+			if err := pusher.Start(ctx); err != nil {
+				log.WithError(err).Error("remote_write push mode failed")
+			}
+		}()
+	}
+
+	// Export the metrics if an OTLP endpoint is configured
+	if cfg.OTLP.Endpoint != "" {
+		exporter, err := otlp.NewExporter(cfg.OTLP, metricsHandler.Gatherer(), sources.Scanner, log)
+		if err != nil {
+			return fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		go func() {
+			if err := exporter.Start(ctx); err != nil {
+				log.WithError(err).Error("OTLP metrics export failed")
+			}
+		}()
+	}
+
+	// Write a textfile for node_exporter instead of serving HTTP
+	if cfg.Output.Textfile != "" {
+		writer := textfile.NewWriter(cfg.Output, metricsHandler.Gatherer(), log)
+		if textfileOnce {
+			return writer.Write()
+		}
+		if err := writer.Start(ctx); err != nil {
+			return fmt.Errorf("textfile output failed: %w", err)
+		}
+		log.Info("Exporter stopped")
+		return nil
+	}
+
 	// Start HTTP server
-	log.WithField("address", cfg.Web.ListenAddress).Info("Starting HTTP server")
+	log.WithFields(logrus.Fields{
+		"address": cfg.Web.ListenAddress,
+		"tls":     server.TLS(),
+	}).Info("Starting HTTP server")
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("HTTP server failed: %w", err)
 	}
@@ -200,37 +294,18 @@ func setupLogging() {
 }
 
 func validateCgroupV2() error {
+	cgroupFS := fsys.NewOS(cfg.Cgroup.Path)
+
 	// Check if cgroup v2 filesystem is mounted
-	if _, err := os.Stat(cfg.Cgroup.Path); os.IsNotExist(err) {
+	if _, err := cgroupFS.Stat("."); errors.Is(err, fs.ErrNotExist) {
 		return fmt.Errorf("cgroup v2 filesystem not found at %s", cfg.Cgroup.Path)
 	}
 
 	// Check if it's actually cgroup v2
-	cgroupVersion := cfg.Cgroup.Path + "/cgroup.controllers"
-	if _, err := os.Stat(cgroupVersion); os.IsNotExist(err) {
+	if _, err := cgroupFS.Stat("cgroup.controllers"); errors.Is(err, fs.ErrNotExist) {
 		return fmt.Errorf("cgroup v2 controllers file not found, ensure cgroup v2 is enabled")
 	}
 
 	log.WithField("path", cfg.Cgroup.Path).Info("cgroup v2 filesystem validated")
 	return nil
 }
-
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"status":"healthy","timestamp":"%s"}`, time.Now().Format(time.RFC3339))
-}
-
-func readyHandler(w http.ResponseWriter, r *http.Request) {
-	// Check if cgroup v2 is still accessible
-	if err := validateCgroupV2(); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusServiceUnavailable)
-		fmt.Fprintf(w, `{"status":"not ready","error":"%s","timestamp":"%s"}`, err.Error(), time.Now().Format(time.RFC3339))
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"status":"ready","timestamp":"%s"}`, time.Now().Format(time.RFC3339))
-}
@@ -0,0 +1,10 @@
+package cgroup
+
+// Enricher attaches additional labels to discovered cgroups, such as the
+// systemd unit or the container a cgroup belongs to. Enrich is called for
+// every cgroup on every scan, so implementations must answer from local
+// state or a cache rather than blocking on remote calls.
+type Enricher interface {
+	Name() string
+	Enrich(cg *CgroupInfo)
+}
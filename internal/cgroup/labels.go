@@ -0,0 +1,103 @@
+package cgroup
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Label schemes select the identifying labels attached to every cgroup series
+const (
+	// LabelSchemeName identifies a cgroup by its dot-joined path in a single
+	// "cgroup" label, e.g. cgroup="system.slice.foo.service"
+	LabelSchemeName = "name"
+	// LabelSchemeHierarchical identifies a cgroup by its full path, parent
+	// path, depth, leaf name and leaf type, so series can be aggregated by
+	// parent without regular expressions
+	LabelSchemeHierarchical = "hierarchical"
+)
+
+// Cgroup types derived from the leaf name of a cgroup path
+const (
+	TypeSlice   = "slice"
+	TypeScope   = "scope"
+	TypeService = "service"
+	TypeOther   = "other"
+)
+
+// invalidLabelChars matches characters not allowed in Prometheus label names
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// LabelName converts a key such as a container label, pod annotation or
+// extended attribute name into a Prometheus label name with the given
+// prefix, replacing invalid characters with underscores
+func LabelName(prefix, key string) string {
+	return prefix + invalidLabelChars.ReplaceAllString(key, "_")
+}
+
+// Parent returns the path of the parent cgroup, or "" for the root cgroup
+func Parent(cgroupPath string) string {
+	if cgroupPath == "/" || cgroupPath == "" {
+		return ""
+	}
+	return path.Dir(cgroupPath)
+}
+
+// Depth returns the nesting depth of a cgroup, 0 for the root cgroup
+func Depth(cgroupPath string) int {
+	trimmed := strings.Trim(cgroupPath, "/")
+	if trimmed == "" {
+		return 0
+	}
+	return strings.Count(trimmed, "/") + 1
+}
+
+// Leaf returns the last component of a cgroup path, or "" for the root cgroup
+func Leaf(cgroupPath string) string {
+	if cgroupPath == "/" || cgroupPath == "" {
+		return ""
+	}
+	return path.Base(cgroupPath)
+}
+
+// Type classifies a cgroup by the systemd unit suffix of its leaf name
+func Type(cgroupPath string) string {
+	leaf := Leaf(cgroupPath)
+	switch {
+	case strings.HasSuffix(leaf, ".slice"):
+		return TypeSlice
+	case strings.HasSuffix(leaf, ".scope"):
+		return TypeScope
+	case strings.HasSuffix(leaf, ".service"):
+		return TypeService
+	default:
+		return TypeOther
+	}
+}
+
+// schemeLabels returns the identifying labels of a cgroup for a label scheme
+func schemeLabels(scheme, cgroupPath, name string) map[string]string {
+	if scheme != LabelSchemeHierarchical {
+		return map[string]string{"cgroup": name}
+	}
+
+	return map[string]string{
+		"path":   cgroupPath,
+		"parent": Parent(cgroupPath),
+		"depth":  strconv.Itoa(Depth(cgroupPath)),
+		"leaf":   Leaf(cgroupPath),
+		"type":   Type(cgroupPath),
+	}
+}
+
+// LabelNames returns the names of the cgroup's labels in sorted order
+func (c *CgroupInfo) LabelNames() []string {
+	names := make([]string, 0, len(c.Labels))
+	for name := range c.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
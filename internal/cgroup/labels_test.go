@@ -0,0 +1,77 @@
+package cgroup
+
+import "testing"
+
+func TestHierarchy(t *testing.T) {
+	tests := []struct {
+		path   string
+		parent string
+		depth  int
+		leaf   string
+		typ    string
+	}{
+		{"/", "", 0, "", TypeOther},
+		{"/system.slice", "/", 1, "system.slice", TypeSlice},
+		{"/system.slice/foo.service", "/system.slice", 2, "foo.service", TypeService},
+		{"/user.slice/user-1000.slice/session-2.scope", "/user.slice/user-1000.slice", 3, "session-2.scope", TypeScope},
+		{"/kubepods/burstable/pod1234", "/kubepods/burstable", 3, "pod1234", TypeOther},
+	}
+
+	for _, tt := range tests {
+		if got := Parent(tt.path); got != tt.parent {
+			t.Errorf("Parent(%q) = %q, want %q", tt.path, got, tt.parent)
+		}
+		if got := Depth(tt.path); got != tt.depth {
+			t.Errorf("Depth(%q) = %d, want %d", tt.path, got, tt.depth)
+		}
+		if got := Leaf(tt.path); got != tt.leaf {
+			t.Errorf("Leaf(%q) = %q, want %q", tt.path, got, tt.leaf)
+		}
+		if got := Type(tt.path); got != tt.typ {
+			t.Errorf("Type(%q) = %q, want %q", tt.path, got, tt.typ)
+		}
+	}
+}
+
+func TestSchemeLabels(t *testing.T) {
+	labels := schemeLabels(LabelSchemeName, "/system.slice/foo.service", "system.slice.foo.service")
+	if len(labels) != 1 || labels["cgroup"] != "system.slice.foo.service" {
+		t.Errorf("Unexpected name scheme labels %v", labels)
+	}
+
+	labels = schemeLabels(LabelSchemeHierarchical, "/system.slice/foo.service", "system.slice.foo.service")
+	want := map[string]string{
+		"path":   "/system.slice/foo.service",
+		"parent": "/system.slice",
+		"depth":  "2",
+		"leaf":   "foo.service",
+		"type":   "service",
+	}
+	if len(labels) != len(want) {
+		t.Fatalf("Expected %d hierarchical labels, got %v", len(want), labels)
+	}
+	for name, value := range want {
+		if labels[name] != value {
+			t.Errorf("Expected %s=%q, got %q", name, value, labels[name])
+		}
+	}
+
+	cg := &CgroupInfo{Labels: labels}
+	names := cg.LabelNames()
+	if names[0] != "depth" || names[len(names)-1] != "type" {
+		t.Errorf("Expected sorted label names, got %v", names)
+	}
+}
+
+func TestLabelName(t *testing.T) {
+	tests := map[string]string{
+		"com.example.team":       "pod_label_com_example_team",
+		"app.kubernetes.io/name": "pod_label_app_kubernetes_io_name",
+		"already_valid":          "pod_label_already_valid",
+	}
+	for key, want := range tests {
+		if got := LabelName("pod_label_", key); got != want {
+			t.Errorf("LabelName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
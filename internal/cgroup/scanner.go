@@ -3,93 +3,282 @@ package cgroup
 import (
 	"context"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/fsys"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/relabel"
 )
 
+// PathLabel is the meta label holding the cgroup path during relabeling
+const PathLabel = relabel.MetaPrefix + "path__"
+
 // Scanner handles cgroup discovery and scanning
 type Scanner struct {
-	cgroupPath string
-	logger     *logrus.Logger
-	maxCgroups int
+	fsys         fsys.FS
+	logger       *logrus.Logger
+	maxCgroups   int
+	labelScheme  string
+	enrichers    []Enricher
+	relabelRules []*relabel.Rule
+	xattrs       []string
+
+	// last is the result of the latest completed scan
+	mutex    sync.Mutex
+	last     []*CgroupInfo
+	lastTime time.Time
+	// lastErr is the error of the latest scan, nil if it succeeded
+	lastErr error
+	// discovered counts the cgroups of the latest scan including the ones
+	// dropped by relabeling
+	discovered int
+	// created keeps the creation time first estimated for each cgroup of
+	// the latest scan, because the estimate moves forward with the
+	// directory mtime whenever children are created or removed
+	created map[createdKey]time.Time
+}
+
+// createdKey identifies a cgroup across scans; a cgroup recreated at the
+// same path gets a new ID
+type createdKey struct {
+	path string
+	id   uint64
 }
 
 // CgroupInfo represents information about a cgroup
 type CgroupInfo struct {
-	Path        string
-	Name        string
+	// Path is the cgroup path as reported in /proc/<pid>/cgroup, e.g.
+	// "/system.slice/foo.service" ("/" for the root cgroup)
+	Path string
+	// ID is the inode number of the cgroup directory, which is the cgroup ID
+	// used by the kernel, bpftool and perf (0 if unknown)
+	ID   uint64
+	Name string
+	// Labels are the identifying labels attached to every series of the
+	// cgroup, as selected by the label scheme
+	Labels map[string]string
+	// Xattrs are the allow-listed extended attributes of the cgroup directory
+	Xattrs      map[string]string
 	Controllers []string
 	Processes   []int
+	// Created estimates when the cgroup was created (zero if unknown)
+	Created     time.Time
 	LastScanned time.Time
 }
 
-// NewScanner creates a new cgroup scanner
-func NewScanner(cgroupPath string, logger *logrus.Logger) *Scanner {
+// NewScanner creates a new cgroup scanner reading from the given cgroupfs root
+func NewScanner(cgroupFS fsys.FS, logger *logrus.Logger) *Scanner {
 	return &Scanner{
-		cgroupPath: cgroupPath,
-		logger:     logger,
-		maxCgroups: 10000,
+		fsys:        cgroupFS,
+		logger:      logger,
+		maxCgroups:  10000,
+		labelScheme: LabelSchemeName,
 	}
 }
 
 // Scan discovers and returns information about all cgroups
 func (s *Scanner) Scan(ctx context.Context) ([]*CgroupInfo, error) {
 	var cgroups []*CgroupInfo
+	discovered := 0
+	created := make(map[createdKey]time.Time)
 
-	err := filepath.Walk(s.cgroupPath, func(path string, info os.FileInfo, err error) error {
+	err := fs.WalkDir(s.fsys, ".", func(name string, d fs.DirEntry, err error) error {
 		if err != nil {
+			if name == "." {
+				return err
+			}
 			return nil // Continue walking on errors
 		}
 
-		if !info.IsDir() {
+		if !d.IsDir() {
 			return nil
 		}
 
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		// Skip if we've reached the maximum number of cgroups
 		if len(cgroups) >= s.maxCgroups {
-			return filepath.SkipDir
+			return fs.SkipDir
 		}
 
 		// Check if this directory contains cgroup.controllers file (indicates it's a cgroup)
-		controllersFile := filepath.Join(path, "cgroup.controllers")
-		if _, err := os.Stat(controllersFile); os.IsNotExist(err) {
+		controllersFile := path.Join(name, "cgroup.controllers")
+		if _, err := fs.Stat(s.fsys, controllersFile); err != nil {
 			return nil
 		}
 
 		// Read controllers
 		controllers, err := s.readControllers(controllersFile)
 		if err != nil {
-			s.logger.WithError(err).WithField("path", path).Debug("Failed to read controllers")
+			s.logger.WithError(err).WithField("path", name).Debug("Failed to read controllers")
 			return nil
 		}
 
+		cgroupPath := "/"
+		if name != "." {
+			cgroupPath += name
+		}
+
+		var id uint64
+		var createdAt time.Time
+		if info, err := d.Info(); err == nil {
+			id = fsys.Inode(info)
+			createdAt = s.creationTime(name, cgroupPath, id, info)
+			created[createdKey{cgroupPath, id}] = createdAt
+		}
+
 		// Create cgroup info
+		cgroupName := s.getCgroupName(cgroupPath)
 		cgroupInfo := &CgroupInfo{
-			Path:        path,
-			Name:        s.getCgroupName(path),
+			Path:        cgroupPath,
+			ID:          id,
+			Name:        cgroupName,
+			Labels:      schemeLabels(s.labelScheme, cgroupPath, cgroupName),
 			Controllers: controllers,
+			Created:     createdAt,
 			LastScanned: time.Now(),
 		}
+		s.readXattrs(name, cgroupInfo)
+
+		for _, enricher := range s.enrichers {
+			enricher.Enrich(cgroupInfo)
+		}
+
+		discovered++
+		// A dropped cgroup is not exported, but its children still are
+		if !s.relabel(cgroupInfo) {
+			return nil
+		}
 
 		cgroups = append(cgroups, cgroupInfo)
 		return nil
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to scan cgroups: %w", err)
+		err = fmt.Errorf("failed to scan cgroups: %w", err)
+		s.mutex.Lock()
+		s.lastErr = err
+		s.mutex.Unlock()
+		return nil, err
 	}
 
 	s.logger.WithField("count", len(cgroups)).Debug("Scanned cgroups")
+
+	s.mutex.Lock()
+	s.last, s.lastTime, s.discovered = cgroups, time.Now(), discovered
+	s.created = created
+	s.lastErr = nil
+	s.mutex.Unlock()
 	return cgroups, nil
 }
 
+// Snapshot returns the cgroups found by the latest completed scan and the
+// time of that scan (zero if there was none)
+func (s *Scanner) Snapshot() ([]*CgroupInfo, time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.last, s.lastTime
+}
+
+// Err returns the error of the latest scan, nil if it succeeded or there was
+// none. A failed scan keeps the snapshot of the previous one.
+func (s *Scanner) Err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastErr
+}
+
+// Discovered returns the number of cgroups found by the latest completed
+// scan, including the ones dropped by relabeling
+func (s *Scanner) Discovered() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.discovered
+}
+
+// creationTime estimates when a cgroup was created. cgroupfs has no birth
+// time, but the directory mtime only changes when children are created or
+// removed and the cgroup.events mtime when the populated state changes, so
+// the earlier of both is close to the creation time, as in cAdvisor. The
+// first estimate of a cgroup is kept, so that later changes of its children
+// do not look like counter resets.
+func (s *Scanner) creationTime(name, cgroupPath string, id uint64, dir fs.FileInfo) time.Time {
+	s.mutex.Lock()
+	created, ok := s.created[createdKey{cgroupPath, id}]
+	s.mutex.Unlock()
+	if ok {
+		return created
+	}
+
+	created = dir.ModTime()
+	if info, err := fs.Stat(s.fsys, path.Join(name, "cgroup.events")); err == nil && info.ModTime().Before(created) {
+		created = info.ModTime()
+	}
+	return created
+}
+
+// relabel applies the relabeling rules to the labels of a cgroup and reports
+// whether the cgroup is kept
+func (s *Scanner) relabel(cg *CgroupInfo) bool {
+	if len(s.relabelRules) == 0 {
+		return true
+	}
+
+	cg.Labels[PathLabel] = cg.Path
+	labels, keep := relabel.Process(cg.Labels, s.relabelRules)
+	if !keep {
+		return false
+	}
+	cg.Labels = labels
+	return true
+}
+
+// readXattrs reads the allow-listed extended attributes of a cgroup directory
+// and adds them as xattr_<name> labels. Missing or unreadable attributes,
+// e.g. trusted.* ones without CAP_SYS_ADMIN, are skipped.
+func (s *Scanner) readXattrs(name string, cg *CgroupInfo) {
+	if len(s.xattrs) == 0 {
+		return
+	}
+
+	attrs := s.xattrs
+	if hasXattrPattern(s.xattrs) {
+		listed, err := fsys.ListXattrs(s.fsys, name)
+		if err != nil {
+			s.logger.WithError(err).WithField("path", name).Debug("Failed to list xattrs")
+			return
+		}
+		attrs = nil
+		for _, attr := range listed {
+			if xattrAllowed(s.xattrs, attr) {
+				attrs = append(attrs, attr)
+			}
+		}
+	}
+
+	for _, attr := range attrs {
+		value, err := fsys.GetXattr(s.fsys, name, attr)
+		if err != nil {
+			continue
+		}
+		if cg.Xattrs == nil {
+			cg.Xattrs = make(map[string]string)
+		}
+		cg.Xattrs[attr] = xattrValue(value)
+		cg.Labels[XattrLabelName(attr)] = cg.Xattrs[attr]
+	}
+}
+
 // readControllers reads the list of available controllers from cgroup.controllers file
 func (s *Scanner) readControllers(controllersFile string) ([]string, error) {
-	data, err := os.ReadFile(controllersFile)
+	data, err := s.fsys.ReadFile(controllersFile)
 	if err != nil {
 		return nil, err
 	}
@@ -99,10 +288,8 @@ func (s *Scanner) readControllers(controllersFile string) ([]string, error) {
 }
 
 // getCgroupName extracts a readable name from the cgroup path
-func (s *Scanner) getCgroupName(path string) string {
-	// Remove the base cgroup path to get relative path
-	relativePath := strings.TrimPrefix(path, s.cgroupPath)
-	relativePath = strings.TrimPrefix(relativePath, "/")
+func (s *Scanner) getCgroupName(cgroupPath string) string {
+	relativePath := strings.TrimPrefix(cgroupPath, "/")
 
 	if relativePath == "" {
 		return "root"
@@ -116,3 +303,25 @@ func (s *Scanner) getCgroupName(path string) string {
 func (s *Scanner) SetMaxCgroups(max int) {
 	s.maxCgroups = max
 }
+
+// SetLabelScheme selects the identifying labels of scanned cgroups
+func (s *Scanner) SetLabelScheme(scheme string) {
+	s.labelScheme = scheme
+}
+
+// SetEnrichers sets the enrichers applied to every scanned cgroup, in order
+func (s *Scanner) SetEnrichers(enrichers []Enricher) {
+	s.enrichers = enrichers
+}
+
+// SetRelabelRules sets the relabeling rules applied to every scanned cgroup
+// after enrichment
+func (s *Scanner) SetRelabelRules(rules []*relabel.Rule) {
+	s.relabelRules = rules
+}
+
+// SetXattrs sets the extended attributes read from every cgroup directory.
+// An entry ending in "*" allows all attributes with that prefix, e.g. "user.*".
+func (s *Scanner) SetXattrs(allow []string) {
+	s.xattrs = allow
+}
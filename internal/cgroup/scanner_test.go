@@ -0,0 +1,339 @@
+package cgroup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/fsys"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/relabel"
+)
+
+func newTestFS(t *testing.T, files map[string]string) *fsys.MemFS {
+	t.Helper()
+
+	mem := fsys.NewMem()
+	for name, data := range files {
+		if err := mem.WriteFile(name, []byte(data)); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	return mem
+}
+
+func TestScanner_Scan(t *testing.T) {
+	mem := newTestFS(t, map[string]string{
+		"cgroup.controllers":                          "cpu io memory pids\n",
+		"system.slice/cgroup.controllers":             "cpu memory\n",
+		"system.slice/foo.service/cgroup.controllers": "",
+		"not-a-cgroup/file":                           "",
+	})
+
+	scanner := NewScanner(mem, logrus.New())
+	cgroups, err := scanner.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	want := map[string]string{
+		"/":                         "root",
+		"/system.slice":             "system.slice",
+		"/system.slice/foo.service": "system.slice.foo.service",
+	}
+	if len(cgroups) != len(want) {
+		t.Fatalf("Expected %d cgroups, got %d", len(want), len(cgroups))
+	}
+	for _, cg := range cgroups {
+		if name, ok := want[cg.Path]; !ok {
+			t.Errorf("Unexpected cgroup %q", cg.Path)
+		} else if cg.Name != name {
+			t.Errorf("Expected name %q for %q, got %q", name, cg.Path, cg.Name)
+		}
+	}
+
+	ids := make(map[uint64]bool)
+	for _, cg := range cgroups {
+		if cg.ID == 0 || ids[cg.ID] {
+			t.Errorf("Expected a unique non-zero ID for %q, got %d", cg.Path, cg.ID)
+		}
+		ids[cg.ID] = true
+	}
+
+	if len(cgroups[0].Controllers) != 4 {
+		t.Errorf("Expected 4 root controllers, got %v", cgroups[0].Controllers)
+	}
+
+	scanner.SetMaxCgroups(1)
+	cgroups, err = scanner.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if len(cgroups) != 1 {
+		t.Errorf("Expected max cgroups to limit the scan to 1, got %d", len(cgroups))
+	}
+}
+
+func TestReadStats(t *testing.T) {
+	mem := newTestFS(t, map[string]string{
+		"a/cpu.stat":      "usage_usec 300\nuser_usec 200\nsystem_usec 100\n",
+		"a/memory.max":    "max\n",
+		"a/memory.high":   "1048576\n",
+		"a/io.stat":       "8:0 rbytes=10 wbytes=20 rios=1 wios=2 dbytes=0 dios=0\n",
+		"a/cpu.pressure":  "some avg10=1.50 avg60=0.00 avg300=0.00 total=1500\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=700\n",
+		"a/cgroup.procs":  "1\n42\n",
+		"a/memory.events": "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n",
+	})
+
+	stat, err := ReadFlatKeyed(mem, "/a", "cpu.stat")
+	if err != nil || stat["user_usec"] != 200 {
+		t.Errorf("ReadFlatKeyed = %v, %v", stat, err)
+	}
+
+	if limit, err := ReadUint(mem, "/a", "memory.max"); err != nil || limit != ^uint64(0) {
+		t.Errorf("ReadUint(max) = %d, %v", limit, err)
+	}
+	if high, err := ReadUint(mem, "/a", "memory.high"); err != nil || high != 1048576 {
+		t.Errorf("ReadUint = %d, %v", high, err)
+	}
+
+	io, err := ReadNestedKeyed(mem, "/a", "io.stat")
+	if err != nil || io["8:0"]["wbytes"] != 20 {
+		t.Errorf("ReadNestedKeyed = %v, %v", io, err)
+	}
+
+	pressure, err := ReadPressure(mem, "/a", "cpu.pressure")
+	if err != nil {
+		t.Fatalf("ReadPressure failed: %v", err)
+	}
+	if pressure.Some.Avg10 != 1.5 || pressure.Some.Total != 1500 || pressure.Full.Total != 700 {
+		t.Errorf("Unexpected pressure %+v", pressure)
+	}
+
+	pids, err := ReadProcs(mem, "/a")
+	if err != nil || len(pids) != 2 || pids[1] != 42 {
+		t.Errorf("ReadProcs = %v, %v", pids, err)
+	}
+
+	if _, err := ReadUint(mem, "/missing", "memory.current"); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
+
+type staticEnricher map[string]string
+
+func (e staticEnricher) Name() string { return "static" }
+
+func (e staticEnricher) Enrich(cg *CgroupInfo) {
+	for name, value := range e {
+		cg.Labels[name] = value
+	}
+}
+
+func TestReadProcCgroup(t *testing.T) {
+	proc := newTestFS(t, map[string]string{
+		"1/cgroup": "0::/init.scope\n",
+		"2/cgroup": "0::/system.slice/foo.service (deleted)\n",
+		"3/cgroup": "0::/../../system.slice/containerd.service\n",
+		"4/cgroup": "0::/..\n",
+		"5/cgroup": "1:name=systemd:/user.slice\n",
+	})
+
+	for pid, want := range map[int]string{1: "/init.scope", 2: "/system.slice/foo.service"} {
+		if got, err := ReadProcCgroup(proc, pid); err != nil || got != want {
+			t.Errorf("ReadProcCgroup(%d) = %q, %v, want %q", pid, got, err, want)
+		}
+	}
+	for _, pid := range []int{3, 4} {
+		if _, err := ReadProcCgroup(proc, pid); !errors.Is(err, ErrOutsideNamespace) {
+			t.Errorf("ReadProcCgroup(%d): expected ErrOutsideNamespace, got %v", pid, err)
+		}
+	}
+	for _, pid := range []int{5, 6} {
+		if _, err := ReadProcCgroup(proc, pid); err == nil {
+			t.Errorf("ReadProcCgroup(%d): expected an error", pid)
+		}
+	}
+}
+
+func TestScanner_Enrichers(t *testing.T) {
+	mem := newTestFS(t, map[string]string{
+		"cgroup.controllers": "cpu\n",
+	})
+
+	scanner := NewScanner(mem, logrus.New())
+	scanner.SetEnrichers([]Enricher{staticEnricher{"team": "infra"}})
+
+	cgroups, err := scanner.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if len(cgroups) != 1 || cgroups[0].Labels["team"] != "infra" || cgroups[0].Labels["cgroup"] != "root" {
+		t.Errorf("Expected enriched root cgroup, got %+v", cgroups)
+	}
+}
+
+func TestScanner_Relabel(t *testing.T) {
+	mem := newTestFS(t, map[string]string{
+		"cgroup.controllers":                          "cpu\n",
+		"system.slice/cgroup.controllers":             "cpu\n",
+		"system.slice/foo.service/cgroup.controllers": "",
+	})
+
+	rules, err := relabel.Compile([]relabel.Config{
+		{SourceLabels: []string{PathLabel}, Regex: "/", Action: relabel.Drop},
+		{SourceLabels: []string{PathLabel}, Regex: "/system.slice/(.+)\\.service", TargetLabel: "service"},
+	})
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	scanner := NewScanner(mem, logrus.New())
+	scanner.SetRelabelRules(rules)
+
+	cgroups, err := scanner.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	// The dropped root cgroup does not hide its children
+	if len(cgroups) != 2 {
+		t.Fatalf("Expected 2 cgroups, got %d", len(cgroups))
+	}
+	for _, cg := range cgroups {
+		if _, ok := cg.Labels[PathLabel]; ok {
+			t.Errorf("Expected meta labels to be removed, got %v", cg.Labels)
+		}
+		if cg.Path == "/system.slice/foo.service" && cg.Labels["service"] != "foo" {
+			t.Errorf("Expected service=foo, got %v", cg.Labels)
+		}
+	}
+}
+
+func TestScanner_Xattrs(t *testing.T) {
+	mem := newTestFS(t, map[string]string{
+		"cgroup.controllers":                          "cpu\n",
+		"system.slice/foo.service/cgroup.controllers": "",
+	})
+	mem.SetXattr("system.slice/foo.service", "trusted.invocation_id", []byte("4f2c8e1a9b3d4c5e8f7a6b5c4d3e2f1a"))
+	mem.SetXattr("system.slice/foo.service", "trusted.delegate", []byte("1"))
+	mem.SetXattr("system.slice/foo.service", "user.team", []byte("infra\n"))
+	mem.SetXattr("system.slice/foo.service", "user.blob", []byte{0x01, 0xff})
+
+	scanner := NewScanner(mem, logrus.New())
+	scanner.SetXattrs([]string{"trusted.invocation_id", "user.*"})
+
+	cgroups, err := scanner.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	for _, cg := range cgroups {
+		if cg.Path != "/system.slice/foo.service" {
+			if len(cg.Xattrs) != 0 {
+				t.Errorf("Expected no xattrs for %s, got %v", cg.Path, cg.Xattrs)
+			}
+			continue
+		}
+
+		want := map[string]string{
+			"xattr_trusted_invocation_id": "4f2c8e1a9b3d4c5e8f7a6b5c4d3e2f1a",
+			"xattr_user_team":             "infra",
+			"xattr_user_blob":             "01ff",
+		}
+		for name, value := range want {
+			if cg.Labels[name] != value {
+				t.Errorf("Expected %s=%q, got %q", name, value, cg.Labels[name])
+			}
+		}
+		if _, ok := cg.Labels["xattr_trusted_delegate"]; ok {
+			t.Errorf("Expected trusted.delegate not to be allowed, got %v", cg.Labels)
+		}
+	}
+}
+
+func TestScanner_CreationTime(t *testing.T) {
+	mem := newTestFS(t, map[string]string{
+		"cgroup.controllers":                          "cpu\n",
+		"system.slice/cgroup.controllers":             "cpu\n",
+		"system.slice/cgroup.events":                  "populated 1\nfrozen 0\n",
+		"system.slice/foo.service/cgroup.events":      "populated 1\nfrozen 0\n",
+		"system.slice/foo.service/cgroup.controllers": "cpu\n",
+	})
+
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	changes := map[string]time.Time{
+		// A child was created later
+		"system.slice":               created.Add(time.Hour),
+		"system.slice/cgroup.events": created,
+		// The populated state changed later
+		"system.slice/foo.service":               created,
+		"system.slice/foo.service/cgroup.events": created.Add(time.Minute),
+	}
+	for name, mtime := range changes {
+		if err := mem.Chtimes(name, mtime); err != nil {
+			t.Fatalf("Chtimes failed: %v", err)
+		}
+	}
+
+	cgroups, err := NewScanner(mem, logrus.New()).Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	for _, cg := range cgroups {
+		if cg.Path != "/" && !cg.Created.Equal(created) {
+			t.Errorf("Expected %s to be created at %v, got %v", cg.Path, created, cg.Created)
+		}
+	}
+}
+
+func TestScanner_CreationTimeStable(t *testing.T) {
+	mem := newTestFS(t, map[string]string{
+		"cgroup.controllers":              "cpu\n",
+		"system.slice/cgroup.controllers": "cpu\n",
+		"system.slice/cgroup.events":      "populated 1\nfrozen 0\n",
+	})
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, name := range []string{".", "system.slice", "system.slice/cgroup.events"} {
+		if err := mem.Chtimes(name, start); err != nil {
+			t.Fatalf("Chtimes failed: %v", err)
+		}
+	}
+
+	scanner := NewScanner(mem, logrus.New())
+	created := func() map[string]time.Time {
+		cgroups, err := scanner.Scan(context.Background())
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		result := make(map[string]time.Time)
+		for _, cg := range cgroups {
+			result[cg.Path] = cg.Created
+		}
+		return result
+	}
+	first := created()
+
+	// Creating children moves the mtime of the parents forward, which
+	// must not change the created timestamp of their counters
+	if err := mem.WriteFile("system.slice/foo.service/cgroup.controllers", []byte("cpu\n")); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	for _, name := range []string{".", "system.slice"} {
+		if err := mem.Chtimes(name, start.Add(time.Hour)); err != nil {
+			t.Fatalf("Chtimes failed: %v", err)
+		}
+	}
+	second := created()
+
+	for _, cgroupPath := range []string{"/", "/system.slice"} {
+		if !first[cgroupPath].Equal(start) || !second[cgroupPath].Equal(start) {
+			t.Errorf("Expected %s to stay created at %v, got %v and %v", cgroupPath, start, first[cgroupPath], second[cgroupPath])
+		}
+	}
+	if _, ok := second["/system.slice/foo.service"]; !ok {
+		t.Error("Expected the new child cgroup to be scanned")
+	}
+}
@@ -0,0 +1,200 @@
+package cgroup
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/fsys"
+)
+
+// Pressure holds the pressure stall information of a cgroup resource
+type Pressure struct {
+	Some PressureLine `json:"some"`
+	Full PressureLine `json:"full"`
+}
+
+// PressureLine holds one line ("some" or "full") of a *.pressure file.
+// Total is the cumulative stall time in microseconds.
+type PressureLine struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	Total  uint64  `json:"total_usec"`
+}
+
+// ReadUint reads a single-value file such as memory.current. A value of
+// "max" is returned as math.MaxUint64.
+func ReadUint(cgroupFS fsys.FS, cgroupPath, file string) (uint64, error) {
+	data, err := cgroupFS.ReadFile(fsys.Join(cgroupPath, file))
+	if err != nil {
+		return 0, err
+	}
+	return parseUint(strings.TrimSpace(string(data)))
+}
+
+// ReadFlatKeyed reads a flat keyed file such as cpu.stat or memory.stat
+func ReadFlatKeyed(cgroupFS fsys.FS, cgroupPath, file string) (map[string]uint64, error) {
+	data, err := cgroupFS.ReadFile(fsys.Join(cgroupPath, file))
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]uint64)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := parseUint(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value in %s: %w", file, err)
+		}
+		values[fields[0]] = value
+	}
+	return values, nil
+}
+
+// ReadNestedKeyed reads a nested keyed file such as io.stat, returning the
+// key=value pairs of each line indexed by the line's first field
+func ReadNestedKeyed(cgroupFS fsys.FS, cgroupPath, file string) (map[string]map[string]uint64, error) {
+	data, err := cgroupFS.ReadFile(fsys.Join(cgroupPath, file))
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]map[string]uint64)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		entry := make(map[string]uint64, len(fields)-1)
+		for _, field := range fields[1:] {
+			key, raw, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			value, err := parseUint(raw)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value in %s: %w", file, err)
+			}
+			entry[key] = value
+		}
+		values[fields[0]] = entry
+	}
+	return values, nil
+}
+
+// ReadPressure reads a pressure stall information file such as cpu.pressure
+func ReadPressure(cgroupFS fsys.FS, cgroupPath, file string) (*Pressure, error) {
+	data, err := cgroupFS.ReadFile(fsys.Join(cgroupPath, file))
+	if err != nil {
+		return nil, err
+	}
+
+	pressure := &Pressure{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var target *PressureLine
+		switch fields[0] {
+		case "some":
+			target = &pressure.Some
+		case "full":
+			target = &pressure.Full
+		default:
+			continue
+		}
+
+		for _, field := range fields[1:] {
+			key, raw, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			switch key {
+			case "avg10", "avg60", "avg300":
+				value, err := strconv.ParseFloat(raw, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid value in %s: %w", file, err)
+				}
+				switch key {
+				case "avg10":
+					target.Avg10 = value
+				case "avg60":
+					target.Avg60 = value
+				default:
+					target.Avg300 = value
+				}
+			case "total":
+				value, err := strconv.ParseUint(raw, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid value in %s: %w", file, err)
+				}
+				target.Total = value
+			}
+		}
+	}
+	return pressure, nil
+}
+
+// ReadProcs returns the PIDs listed in a cgroup's cgroup.procs file
+func ReadProcs(cgroupFS fsys.FS, cgroupPath string) ([]int, error) {
+	data, err := cgroupFS.ReadFile(fsys.Join(cgroupPath, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(string(data))
+	pids := make([]int, 0, len(fields))
+	for _, field := range fields {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pid in cgroup.procs: %w", err)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// ErrOutsideNamespace is returned by ReadProcCgroup for a process whose
+// cgroup is outside of the cgroup namespace of the exporter
+var ErrOutsideNamespace = errors.New("cgroup is outside of the cgroup namespace")
+
+// ReadProcCgroup returns the cgroup v2 path of a process from the unified
+// hierarchy entry ("0::<path>") of /proc/<pid>/cgroup. The " (deleted)"
+// suffix of a removed cgroup is stripped. Paths are relative to the cgroup
+// namespace of the reader, so a cgroup outside of it, shown with a "/.."
+// prefix, is reported as ErrOutsideNamespace.
+func ReadProcCgroup(procFS fsys.FS, pid int) (string, error) {
+	data, err := procFS.ReadFile(strconv.Itoa(pid) + "/cgroup")
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		cgroupPath, ok := strings.CutPrefix(line, "0::")
+		if !ok {
+			continue
+		}
+		cgroupPath = strings.TrimSuffix(cgroupPath, " (deleted)")
+		if cgroupPath == "/.." || strings.HasPrefix(cgroupPath, "/../") {
+			return "", fmt.Errorf("process %d: %w", pid, ErrOutsideNamespace)
+		}
+		return cgroupPath, nil
+	}
+	return "", fmt.Errorf("process %d is not in a cgroup v2 hierarchy", pid)
+}
+
+func parseUint(raw string) (uint64, error) {
+	if raw == "max" {
+		return math.MaxUint64, nil
+	}
+	return strconv.ParseUint(raw, 10, 64)
+}
@@ -0,0 +1,51 @@
+package cgroup
+
+import (
+	"encoding/hex"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// XattrLabelName converts an extended attribute name such as
+// "trusted.invocation_id" into the label "xattr_trusted_invocation_id"
+func XattrLabelName(attr string) string {
+	return LabelName("xattr_", attr)
+}
+
+// hasXattrPattern reports whether an allow-list contains prefix patterns
+func hasXattrPattern(allow []string) bool {
+	for _, entry := range allow {
+		if strings.HasSuffix(entry, "*") {
+			return true
+		}
+	}
+	return false
+}
+
+// xattrAllowed reports whether an attribute matches an allow-list entry
+func xattrAllowed(allow []string, attr string) bool {
+	for _, entry := range allow {
+		if prefix, ok := strings.CutSuffix(entry, "*"); ok {
+			if strings.HasPrefix(attr, prefix) {
+				return true
+			}
+		} else if entry == attr {
+			return true
+		}
+	}
+	return false
+}
+
+// xattrValue converts an attribute value into a label value. Printable text
+// is used as is (without trailing NULs and whitespace), binary values are
+// hex-encoded.
+func xattrValue(value []byte) string {
+	text := strings.TrimRightFunc(string(value), func(r rune) bool {
+		return r == 0 || unicode.IsSpace(r)
+	})
+	if utf8.ValidString(text) && strings.IndexFunc(text, func(r rune) bool { return !unicode.IsPrint(r) }) < 0 {
+		return text
+	}
+	return hex.EncodeToString(value)
+}
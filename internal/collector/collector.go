@@ -1,17 +1,26 @@
 package collector
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/sirupsen/logrus"
 
-	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
 	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/fsys"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/relabel"
 )
 
+// usecPerSecond converts the microsecond counters of cgroup v2 to seconds
+const usecPerSecond = 1e6
+
 // Collector interface defines the contract for all collectors
 type Collector interface {
 	prometheus.Collector
@@ -19,34 +28,149 @@ type Collector interface {
 	Enabled() bool
 }
 
+// Sources groups the inputs the collectors read from: the cgroupfs and procfs
+// roots and the enrichers that label discovered cgroups
+type Sources struct {
+	Cgroup    fsys.FS
+	Proc      fsys.FS
+	Enrichers []cgroup.Enricher
+	// Scanner discovers the cgroups. If nil, NewCollectorsWithSources
+	// creates one that its collectors share. The collectors export the
+	// latest scan; gather them through a Gatherer to scan on every scrape.
+	Scanner *cgroup.Scanner
+}
+
+// HostSources returns the host cgroupfs and procfs roots from the configuration
+func HostSources(cfg *config.Config) Sources {
+	procPath := cfg.Proc.Path
+	if procPath == "" {
+		procPath = "/proc"
+	}
+
+	return Sources{
+		Cgroup: fsys.NewOS(cfg.Cgroup.Path),
+		Proc:   fsys.NewOS(procPath),
+	}
+}
+
 // BaseCollector provides common functionality for all collectors
 type BaseCollector struct {
 	name    string
 	enabled bool
 	config  *config.Config
 	logger  *logrus.Logger
+	fs      Sources
 	scanner *cgroup.Scanner
 	mutex   sync.RWMutex
 
+	// metricRules are the metric relabeling rules applied to every series
+	metricRules []*relabel.Rule
+
+	// health tracks the outcome of the scrapes
+	health healthTracker
+
 	// Metrics cache
-	cache      map[string]interface{}
-	cacheTime  time.Time
-	cacheTTL   time.Duration
+	cache     map[string]interface{}
+	cacheTime time.Time
+	cacheTTL  time.Duration
 }
 
 // NewBaseCollector creates a new base collector
-func NewBaseCollector(name string, enabled bool, cfg *config.Config, logger *logrus.Logger) *BaseCollector {
-	scanner := cgroup.NewScanner(cfg.Cgroup.Path, logger)
+func NewBaseCollector(name string, enabled bool, cfg *config.Config, sources Sources, logger *logrus.Logger) *BaseCollector {
+	scanner := sources.Scanner
+	if scanner == nil {
+		scanner = NewScanner(cfg, sources, logger)
+	}
+	// The rules are validated with the configuration
+	metricRules, err := relabel.Compile(cfg.MetricRelabelConfigs)
+	if err != nil {
+		logger.WithError(err).Error("Ignoring invalid metric_relabel_configs")
+	}
 
 	return &BaseCollector{
-		name:      name,
-		enabled:   enabled,
-		config:    cfg,
-		logger:    logger,
-		scanner:   scanner,
-		cache:     make(map[string]interface{}),
-		cacheTTL:  cfg.Advanced.CacheDuration,
+		name:        name,
+		enabled:     enabled,
+		config:      cfg,
+		logger:      logger,
+		fs:          sources,
+		scanner:     scanner,
+		metricRules: metricRules,
+		cache:       make(map[string]interface{}),
+		cacheTTL:    cfg.Advanced.CacheDuration,
+	}
+}
+
+// NewScanner creates a cgroup scanner for the sources, configured with the
+// label scheme, enrichers and relabeling rules of the configuration
+func NewScanner(cfg *config.Config, sources Sources, logger *logrus.Logger) *cgroup.Scanner {
+	scanner := cgroup.NewScanner(sources.Cgroup, logger)
+	if cfg.Advanced.MaxCgroups > 0 {
+		scanner.SetMaxCgroups(cfg.Advanced.MaxCgroups)
+	}
+	if cfg.Cgroup.LabelScheme != "" {
+		scanner.SetLabelScheme(cfg.Cgroup.LabelScheme)
+	}
+	scanner.SetXattrs(cfg.Cgroup.Xattrs)
+	scanner.SetEnrichers(sources.Enrichers)
+	if rules, err := relabel.Compile(cfg.RelabelConfigs); err != nil {
+		logger.WithError(err).Error("Ignoring invalid relabel_configs")
+	} else {
+		scanner.SetRelabelRules(rules)
+	}
+	return scanner
+}
+
+// Gatherer scans the cgroups once and then gathers collectors that share the
+// scanner, so that a scrape walks the cgroup tree once and every collector
+// exports the same cgroups
+type Gatherer struct {
+	scanner  *cgroup.Scanner
+	gatherer prometheus.Gatherer
+}
+
+// NewGatherer creates a gatherer scanning with scanner before gathering
+func NewGatherer(scanner *cgroup.Scanner, gatherer prometheus.Gatherer) *Gatherer {
+	return &Gatherer{scanner: scanner, gatherer: gatherer}
+}
+
+// Gather implements prometheus.Gatherer. The collectors report a failed
+// scan.
+func (g *Gatherer) Gather() ([]*dto.MetricFamily, error) {
+	g.scanner.Scan(context.Background())
+	return g.gatherer.Gather()
+}
+
+// cgroups returns the cgroups of the latest scan, or the error of that scan
+func (bc *BaseCollector) cgroups() ([]*cgroup.CgroupInfo, error) {
+	if err := bc.scanner.Err(); err != nil {
+		return nil, err
 	}
+	cgroups, _ := bc.scanner.Snapshot()
+	return cgroups, nil
+}
+
+// newDesc creates the description of a per-cgroup metric with the given
+// metric-specific labels, subject to the metric relabeling rules
+func (bc *BaseCollector) newDesc(subsystem, name, help string, variableLabels ...string) *metricDesc {
+	return &metricDesc{
+		fqName:    prometheus.BuildFQName("cgroup", subsystem, name),
+		help:      help,
+		labels:    variableLabels,
+		rules:     bc.metricRules,
+		prefilter: !relabel.References(bc.metricRules, variableLabels),
+		descs:     make(map[string]*prometheus.Desc),
+	}
+}
+
+// anyWanted reports whether any of the metrics may be exported for a cgroup,
+// so that files feeding only dropped metrics are not read at all
+func anyWanted(cg *cgroup.CgroupInfo, descs ...*metricDesc) bool {
+	for _, d := range descs {
+		if d != nil && d.wanted(cg) {
+			return true
+		}
+	}
+	return false
 }
 
 // Name returns the collector name
@@ -89,34 +213,67 @@ func (bc *BaseCollector) ClearCache() {
 	bc.cache = make(map[string]interface{})
 }
 
-// NewCollectors creates and returns all enabled collectors
+// collectPressure emits the cumulative "some" and "full" stall times of a
+// cgroup's *.pressure file
+func (bc *BaseCollector) collectPressure(ch chan<- prometheus.Metric, desc *metricDesc, cg *cgroup.CgroupInfo, file string, errs *readErrors) {
+	if !desc.wanted(cg) {
+		return
+	}
+
+	pressure, err := cgroup.ReadPressure(bc.fs.Cgroup, cg.Path, file)
+	if err != nil {
+		errs.add(err)
+		bc.logger.WithError(err).WithField("cgroup", cg.Path).Debugf("Failed to read %s", file)
+		return
+	}
+
+	desc.send(ch, cg, prometheus.CounterValue, float64(pressure.Some.Total)/usecPerSecond, "some")
+	desc.send(ch, cg, prometheus.CounterValue, float64(pressure.Full.Total)/usecPerSecond, "full")
+}
+
+// NewCollectors creates and returns all enabled collectors reading from the host
 func NewCollectors(cfg *config.Config, logger *logrus.Logger) (map[string]Collector, error) {
+	return NewCollectorsWithSources(cfg, HostSources(cfg), logger)
+}
+
+// NewCollectorsWithSources creates and returns all enabled collectors reading
+// from the given sources
+func NewCollectorsWithSources(cfg *config.Config, sources Sources, logger *logrus.Logger) (map[string]Collector, error) {
 	collectors := make(map[string]Collector)
+	if sources.Scanner == nil {
+		sources.Scanner = NewScanner(cfg, sources, logger)
+	}
 
 	// CPU Collector
 	if cfg.Collectors.CPU.Enabled {
-		cpuCollector := NewCPUCollector(cfg, logger)
+		cpuCollector := NewCPUCollector(cfg, sources, logger)
 		collectors["cpu"] = cpuCollector
 	}
 
 	// Memory Collector
 	if cfg.Collectors.Memory.Enabled {
-		memoryCollector := NewMemoryCollector(cfg, logger)
+		memoryCollector := NewMemoryCollector(cfg, sources, logger)
 		collectors["memory"] = memoryCollector
 	}
 
 	// I/O Collector
 	if cfg.Collectors.IO.Enabled {
-		ioCollector := NewIOCollector(cfg, logger)
+		ioCollector := NewIOCollector(cfg, sources, logger)
 		collectors["io"] = ioCollector
 	}
 
 	// PIDs Collector
 	if cfg.Collectors.PIDs.Enabled {
-		pidsCollector := NewPIDsCollector(cfg, logger)
+		pidsCollector := NewPIDsCollector(cfg, sources, logger)
 		collectors["pids"] = pidsCollector
 	}
 
+	// cgroup info Collector
+	if cfg.Collectors.Info.Enabled {
+		infoCollector := NewInfoCollector(cfg, sources, logger)
+		collectors["info"] = infoCollector
+	}
+
 	if len(collectors) == 0 {
 		return nil, fmt.Errorf("no collectors enabled")
 	}
@@ -125,6 +282,126 @@ func NewCollectors(cfg *config.Config, logger *logrus.Logger) (map[string]Collec
 	return collectors, nil
 }
 
+// metricDesc describes a per-cgroup metric. The identifying labels of a
+// cgroup depend on the label scheme and on enrichment, so concrete
+// descriptors are built and cached per cgroup label set.
+type metricDesc struct {
+	fqName string
+	help   string
+	labels []string
+
+	// rules are the metric relabeling rules. With prefilter set, no rule
+	// depends on the metric-specific labels, so whether a cgroup's series
+	// are dropped is known before reading any file.
+	rules     []*relabel.Rule
+	prefilter bool
+
+	mutex sync.Mutex
+	descs map[string]*prometheus.Desc
+}
+
+// cgroupLabelName returns the exported name of a cgroup label. A cgroup label
+// that clashes with a metric-specific label gets a "cgroup_" prefix.
+func (d *metricDesc) cgroupLabelName(name string) string {
+	for _, own := range d.labels {
+		if own == name {
+			return "cgroup_" + name
+		}
+	}
+	return name
+}
+
+// wanted reports whether series of the metric may be exported for a cgroup
+func (d *metricDesc) wanted(cg *cgroup.CgroupInfo) bool {
+	if len(d.rules) == 0 || !d.prefilter {
+		return true
+	}
+
+	labels := make(map[string]string, len(cg.Labels)+1)
+	for name, value := range cg.Labels {
+		labels[d.cgroupLabelName(name)] = value
+	}
+	labels[relabel.NameLabel] = d.fqName
+
+	_, keep := relabel.Process(labels, d.rules)
+	return keep
+}
+
+// send emits a constant metric for a cgroup unless the metric relabeling
+// rules drop it
+func (d *metricDesc) send(ch chan<- prometheus.Metric, cg *cgroup.CgroupInfo, valueType prometheus.ValueType, value float64, labelValues ...string) {
+	if len(d.rules) == 0 {
+		ch <- d.metric(cg, valueType, value, labelValues...)
+		return
+	}
+
+	labels := make(map[string]string, len(cg.Labels)+len(d.labels)+1)
+	for name, value := range cg.Labels {
+		labels[d.cgroupLabelName(name)] = value
+	}
+	for i, name := range d.labels {
+		labels[name] = labelValues[i]
+	}
+	labels[relabel.NameLabel] = d.fqName
+
+	labels, keep := relabel.Process(labels, d.rules)
+	if !keep {
+		return
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = labels[name]
+	}
+
+	ch <- constMetric(d.desc(names), cg, valueType, value, values...)
+}
+
+// metric builds a constant metric for a cgroup
+func (d *metricDesc) metric(cg *cgroup.CgroupInfo, valueType prometheus.ValueType, value float64, labelValues ...string) prometheus.Metric {
+	cgroupLabels := cg.LabelNames()
+	names := make([]string, 0, len(cgroupLabels)+len(d.labels))
+	values := make([]string, 0, len(cgroupLabels)+len(labelValues))
+
+	for _, name := range cgroupLabels {
+		values = append(values, cg.Labels[name])
+		names = append(names, d.cgroupLabelName(name))
+	}
+	names = append(names, d.labels...)
+	values = append(values, labelValues...)
+
+	return constMetric(d.desc(names), cg, valueType, value, values...)
+}
+
+// constMetric builds a constant metric. Counters carry the creation time of
+// the cgroup as created timestamp, so that a cgroup recreated at the same
+// path is seen as a counter reset.
+func constMetric(desc *prometheus.Desc, cg *cgroup.CgroupInfo, valueType prometheus.ValueType, value float64, labelValues ...string) prometheus.Metric {
+	if valueType == prometheus.CounterValue && !cg.Created.IsZero() {
+		return prometheus.MustNewConstMetricWithCreatedTimestamp(desc, valueType, value, cg.Created, labelValues...)
+	}
+	return prometheus.MustNewConstMetric(desc, valueType, value, labelValues...)
+}
+
+func (d *metricDesc) desc(labelNames []string) *prometheus.Desc {
+	key := strings.Join(labelNames, ",")
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	desc, ok := d.descs[key]
+	if !ok {
+		desc = prometheus.NewDesc(d.fqName, d.help, labelNames, nil)
+		d.descs[key] = desc
+	}
+	return desc
+}
+
 // CollectorMetrics holds common metrics for all collectors
 type CollectorMetrics struct {
 	ScrapeDuration prometheus.Histogram
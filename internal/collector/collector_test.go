@@ -1,11 +1,21 @@
 package collector
 
 import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/sirupsen/logrus"
 
 	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/fsys"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/relabel"
 )
 
 func TestNewCollectors(t *testing.T) {
@@ -30,7 +40,7 @@ func TestNewCollectors(t *testing.T) {
 			},
 		},
 		Advanced: config.AdvancedConfig{
-			CacheDuration: 60,
+			CacheDuration: 60 * time.Second,
 		},
 		Cgroup: config.CgroupConfig{
 			Path: "/sys/fs/cgroup",
@@ -73,7 +83,7 @@ func TestNewCollectors_NoEnabled(t *testing.T) {
 			},
 		},
 		Advanced: config.AdvancedConfig{
-			CacheDuration: 60,
+			CacheDuration: 60 * time.Second,
 		},
 		Cgroup: config.CgroupConfig{
 			Path: "/sys/fs/cgroup",
@@ -91,7 +101,7 @@ func TestNewCollectors_NoEnabled(t *testing.T) {
 func TestBaseCollector(t *testing.T) {
 	cfg := &config.Config{
 		Advanced: config.AdvancedConfig{
-			CacheDuration: 60,
+			CacheDuration: 60 * time.Second,
 		},
 		Cgroup: config.CgroupConfig{
 			Path: "/sys/fs/cgroup",
@@ -100,7 +110,7 @@ func TestBaseCollector(t *testing.T) {
 
 	logger := logrus.New()
 
-	bc := NewBaseCollector("test", true, cfg, logger)
+	bc := NewBaseCollector("test", true, cfg, Sources{Cgroup: fsys.NewMem(), Proc: fsys.NewMem()}, logger)
 
 	if bc.Name() != "test" {
 		t.Errorf("Expected name 'test', got '%s'", bc.Name())
@@ -137,3 +147,410 @@ func TestBaseCollector(t *testing.T) {
 		t.Error("Cache should be empty after clearing")
 	}
 }
+
+// newTestSources builds a synthetic host with a root cgroup and one service
+func newTestSources(t *testing.T) Sources {
+	t.Helper()
+
+	cgroupFS := fsys.NewMem()
+	procFS := fsys.NewMem()
+
+	files := map[*fsys.MemFS]map[string]string{
+		cgroupFS: {
+			"cgroup.controllers":                          "cpu io memory pids\n",
+			"cpu.stat":                                    "usage_usec 9000000\nuser_usec 6000000\nsystem_usec 3000000\n",
+			"system.slice/cgroup.controllers":             "cpu io memory pids\n",
+			"system.slice/foo.service/cgroup.controllers": "cpu io memory pids\n",
+			"system.slice/foo.service/cgroup.procs":       "100\n101\n",
+			"system.slice/foo.service/cpu.stat":           "usage_usec 3000000\nuser_usec 2000000\nsystem_usec 1000000\nnr_periods 10\nthrottled_usec 500000\n",
+			"system.slice/foo.service/cpu.pressure":       "some avg10=0.00 avg60=0.00 avg300=0.00 total=250000\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n",
+			"system.slice/foo.service/memory.current":     "4096\n",
+			"system.slice/foo.service/memory.max":         "max\n",
+			"system.slice/foo.service/memory.stat":        "anon 1024\nfile 2048\n",
+			"system.slice/foo.service/memory.events":      "oom 2\noom_kill 1\n",
+			"system.slice/foo.service/io.stat":            "8:0 rbytes=512 wbytes=1024 rios=1 wios=2\n",
+		},
+		procFS: {
+			"partitions": "major minor  #blocks  name\n\n   8        0  104857600 sda\n",
+			"100/stat":   "100 (foo) S 1 100 100 0 -1",
+			"101/stat":   "101 (foo (worker)) R 1 100 100 0 -1",
+		},
+	}
+	for mem, contents := range files {
+		for name, data := range contents {
+			if err := mem.WriteFile(name, []byte(data)); err != nil {
+				t.Fatalf("Failed to write %s: %v", name, err)
+			}
+		}
+	}
+
+	return Sources{Cgroup: cgroupFS, Proc: procFS}
+}
+
+// newTestCollectors creates the collectors of cfg with a shared scanner, and a
+// gatherer of registry that scans the cgroups before each collection
+func newTestCollectors(t *testing.T, cfg *config.Config, sources Sources) (map[string]Collector, *prometheus.Registry, prometheus.Gatherer) {
+	t.Helper()
+
+	sources.Scanner = NewScanner(cfg, sources, logrus.New())
+	collectors, err := NewCollectorsWithSources(cfg, sources, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create collectors: %v", err)
+	}
+	registry := prometheus.NewRegistry()
+	return collectors, registry, NewGatherer(sources.Scanner, registry)
+}
+
+// gatherValue returns the value of the metric with the given name and labels
+func gatherValue(t *testing.T, gatherer prometheus.Gatherer, name string, labels map[string]string) (float64, bool) {
+	t.Helper()
+
+	families, err := gatherer.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if !labelsMatch(metric, labels) {
+				continue
+			}
+			switch {
+			case metric.Counter != nil:
+				return metric.Counter.GetValue(), true
+			case metric.Gauge != nil:
+				return metric.Gauge.GetValue(), true
+			}
+		}
+	}
+	return 0, false
+}
+
+func labelsMatch(metric *dto.Metric, labels map[string]string) bool {
+	matched := 0
+	for _, pair := range metric.GetLabel() {
+		if value, ok := labels[pair.GetName()]; ok {
+			if value != pair.GetValue() {
+				return false
+			}
+			matched++
+		}
+	}
+	return matched == len(labels)
+}
+
+func TestCollectors_SyntheticHierarchy(t *testing.T) {
+	cfg := &config.Config{
+		Collectors: config.CollectorsConfig{
+			CPU:    config.CPUCollectorConfig{Enabled: true, IncludePressure: true},
+			Memory: config.MemoryCollectorConfig{Enabled: true, IncludePressure: true},
+			IO:     config.IOCollectorConfig{Enabled: true, IncludePressure: true},
+			PIDs:   config.PIDsCollectorConfig{Enabled: true},
+			Info:   config.InfoCollectorConfig{Enabled: true},
+		},
+		Advanced: config.AdvancedConfig{
+			MaxCgroups:    100,
+			CacheDuration: 60 * time.Second,
+		},
+	}
+
+	sources := newTestSources(t)
+	collectors, registry, gatherer := newTestCollectors(t, cfg, sources)
+
+	serviceDir, err := sources.Cgroup.Stat("system.slice/foo.service")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	serviceID := strconv.FormatUint(fsys.Inode(serviceDir), 10)
+
+	for _, coll := range collectors {
+		registry.MustRegister(coll)
+	}
+
+	service := "system.slice.foo.service"
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"cgroup_cpu_usage_seconds_total", map[string]string{"cgroup": service, "mode": "user"}, 2},
+		{"cgroup_cpu_system_seconds_total", map[string]string{"cgroup": service}, 1},
+		{"cgroup_cpu_throttled_seconds_total", map[string]string{"cgroup": service}, 0.5},
+		{"cgroup_cpu_periods_total", map[string]string{"cgroup": service}, 10},
+		{"cgroup_cpu_pressure_seconds_total", map[string]string{"cgroup": service, "type": "some"}, 0.25},
+		{"cgroup_cpu_user_seconds_total", map[string]string{"cgroup": "root"}, 6},
+		{"cgroup_memory_usage_bytes", map[string]string{"cgroup": service}, 4096},
+		{"cgroup_memory_cache_bytes", map[string]string{"cgroup": service}, 2048},
+		{"cgroup_memory_rss_bytes", map[string]string{"cgroup": service}, 1024},
+		{"cgroup_memory_oom_events_total", map[string]string{"cgroup": service}, 2},
+		{"cgroup_io_write_bytes_total", map[string]string{"cgroup": service, "device": "sda"}, 1024},
+		{"cgroup_io_read_operations_total", map[string]string{"cgroup": service, "device": "sda"}, 1},
+		{"cgroup_processes_count", map[string]string{"cgroup": service}, 2},
+		{"cgroup_processes_running", map[string]string{"cgroup": service}, 1},
+		{"cgroup_processes_sleeping", map[string]string{"cgroup": service}, 1},
+		{"cgroup_info", map[string]string{"cgroup": service, "id": serviceID}, 1},
+	}
+
+	for _, tt := range tests {
+		got, ok := gatherValue(t, gatherer, tt.name, tt.labels)
+		if !ok {
+			t.Errorf("Metric %s%v not found", tt.name, tt.labels)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Metric %s%v = %v, want %v", tt.name, tt.labels, got, tt.want)
+		}
+	}
+
+	// An unlimited memory.max must not be exported as a limit
+	if _, ok := gatherValue(t, gatherer, "cgroup_memory_limit_bytes", map[string]string{"cgroup": service}); ok {
+		t.Error("Expected no memory limit for a cgroup with memory.max=max")
+	}
+}
+
+func TestCollectors_HierarchicalLabels(t *testing.T) {
+	cfg := &config.Config{
+		Cgroup: config.CgroupConfig{
+			LabelScheme: "hierarchical",
+		},
+		Collectors: config.CollectorsConfig{
+			CPU: config.CPUCollectorConfig{Enabled: true, IncludePressure: true},
+		},
+		Advanced: config.AdvancedConfig{
+			CacheDuration: 60 * time.Second,
+		},
+	}
+
+	collectors, registry, gatherer := newTestCollectors(t, cfg, newTestSources(t))
+
+	for _, coll := range collectors {
+		registry.MustRegister(coll)
+	}
+
+	labels := map[string]string{
+		"path":   "/system.slice/foo.service",
+		"parent": "/system.slice",
+		"depth":  "2",
+		"leaf":   "foo.service",
+		"type":   "service",
+	}
+	if got, ok := gatherValue(t, gatherer, "cgroup_cpu_user_seconds_total", labels); !ok || got != 2 {
+		t.Errorf("Expected hierarchical user time 2, got %v (found %v)", got, ok)
+	}
+
+	// The pressure "type" label takes precedence over the cgroup type
+	pressureLabels := map[string]string{
+		"path":        "/system.slice/foo.service",
+		"cgroup_type": "service",
+		"type":        "some",
+	}
+	if got, ok := gatherValue(t, gatherer, "cgroup_cpu_pressure_seconds_total", pressureLabels); !ok || got != 0.25 {
+		t.Errorf("Expected pressure with prefixed cgroup type 0.25, got %v (found %v)", got, ok)
+	}
+}
+
+// countingFS records which files are read
+type countingFS struct {
+	fsys.FS
+	mutex sync.Mutex
+	reads map[string]int
+}
+
+func (c *countingFS) ReadFile(name string) ([]byte, error) {
+	c.mutex.Lock()
+	c.reads[name]++
+	c.mutex.Unlock()
+	return c.FS.ReadFile(name)
+}
+
+func TestCollectors_MetricRelabel(t *testing.T) {
+	cfg := &config.Config{
+		Collectors: config.CollectorsConfig{
+			Memory: config.MemoryCollectorConfig{Enabled: true},
+			IO:     config.IOCollectorConfig{Enabled: true},
+		},
+		MetricRelabelConfigs: []relabel.Config{
+			// memory.stat fields only for the service
+			{
+				SourceLabels: []string{"__name__", "cgroup"},
+				Regex:        "cgroup_memory_(cache|rss)_bytes;root",
+				Action:       "drop",
+			},
+			// Depends on the device, so io.stat must still be read
+			{SourceLabels: []string{"device"}, Regex: "sda", Action: "drop"},
+			{SourceLabels: []string{"cgroup"}, Regex: "system\\.slice\\.(.+)", TargetLabel: "unit"},
+		},
+		Advanced: config.AdvancedConfig{
+			CacheDuration: 60 * time.Second,
+		},
+	}
+
+	sources := newTestSources(t)
+	cgroupFS := &countingFS{FS: sources.Cgroup, reads: make(map[string]int)}
+	sources.Cgroup = cgroupFS
+
+	collectors, registry, gatherer := newTestCollectors(t, cfg, sources)
+	for _, coll := range collectors {
+		registry.MustRegister(coll)
+	}
+
+	service := map[string]string{"cgroup": "system.slice.foo.service", "unit": "foo.service"}
+	if got, ok := gatherValue(t, gatherer, "cgroup_memory_cache_bytes", service); !ok || got != 2048 {
+		t.Errorf("Expected relabeled service cache 2048, got %v (found %v)", got, ok)
+	}
+	if _, ok := gatherValue(t, gatherer, "cgroup_io_read_bytes_total", map[string]string{"device": "sda"}); ok {
+		t.Error("Expected sda series to be dropped")
+	}
+
+	// The root cgroup's memory.stat feeds only dropped series
+	if n := cgroupFS.reads["memory.stat"]; n != 0 {
+		t.Errorf("Expected root memory.stat not to be read, got %d reads", n)
+	}
+	if n := cgroupFS.reads["system.slice/foo.service/memory.stat"]; n == 0 {
+		t.Error("Expected service memory.stat to be read")
+	}
+}
+
+func TestCollectors_Scoped(t *testing.T) {
+	cfg := &config.Config{
+		Collectors: config.CollectorsConfig{
+			CPU:  config.CPUCollectorConfig{Enabled: true},
+			Info: config.InfoCollectorConfig{Enabled: true},
+		},
+		Advanced: config.AdvancedConfig{MaxCgroups: 100},
+	}
+
+	collectors, registry, gatherer := newTestCollectors(t, cfg, newTestSources(t))
+
+	scope := func(cgroupPath string) bool {
+		return strings.HasPrefix(cgroupPath, "/system.slice/")
+	}
+	for _, coll := range collectors {
+		registry.MustRegister(Scoped(coll, scope))
+	}
+
+	service := "system.slice.foo.service"
+	if _, ok := gatherValue(t, gatherer, "cgroup_cpu_user_seconds_total", map[string]string{"cgroup": service}); !ok {
+		t.Error("Expected metrics of a cgroup in scope")
+	}
+	for _, cgroup := range []string{"root", "system.slice"} {
+		if _, ok := gatherValue(t, gatherer, "cgroup_info", map[string]string{"cgroup": cgroup}); ok {
+			t.Errorf("Expected no metrics of cgroup %s out of scope", cgroup)
+		}
+	}
+	if _, ok := gatherValue(t, gatherer, "prometheus_cgroup_v2_exporter_cpu_cgroups_scraped", nil); ok {
+		t.Error("Expected no host-wide collector metrics in a scoped collection")
+	}
+}
+
+func TestCollectors_CreatedTimestamp(t *testing.T) {
+	cfg := &config.Config{
+		Collectors: config.CollectorsConfig{
+			CPU: config.CPUCollectorConfig{Enabled: true},
+		},
+		Advanced: config.AdvancedConfig{MaxCgroups: 100},
+	}
+
+	sources := newTestSources(t)
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := sources.Cgroup.(*fsys.MemFS).Chtimes("system.slice/foo.service", created); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+
+	collectors, registry, gatherer := newTestCollectors(t, cfg, sources)
+	registry.MustRegister(collectors["cpu"])
+
+	families, err := gatherer.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	found := false
+	for _, family := range families {
+		if family.GetName() != "cgroup_cpu_user_seconds_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if !labelsMatch(metric, map[string]string{"cgroup": "system.slice.foo.service"}) {
+				continue
+			}
+			found = true
+			if got := metric.GetCounter().GetCreatedTimestamp().AsTime(); !got.Equal(created) {
+				t.Errorf("Expected created timestamp %v, got %v", created, got)
+			}
+		}
+	}
+	if !found {
+		t.Error("Metric cgroup_cpu_user_seconds_total not found")
+	}
+}
+
+func TestCollectors_Health(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "cgroup")
+	cfg := &config.Config{
+		Collectors: config.CollectorsConfig{Info: config.InfoCollectorConfig{Enabled: true}},
+		Advanced:   config.AdvancedConfig{MaxCgroups: 100},
+	}
+	collectors, registry, gatherer := newTestCollectors(t, cfg, Sources{Cgroup: fsys.NewOS(root), Proc: fsys.NewMem()})
+	info := collectors["info"].(*InfoCollector)
+	registry.MustRegister(info)
+
+	if health := info.Health(); !health.Healthy() || !health.LastSuccess.IsZero() {
+		t.Errorf("Expected no scrapes yet, got %+v", health)
+	}
+
+	// The scans fail while the cgroupfs root is missing
+	for i := 0; i < 2; i++ {
+		gatherer.Gather()
+	}
+	health := info.Health()
+	if health.Healthy() || health.ConsecutiveFailures != 2 || health.LastError == "" {
+		t.Errorf("Expected 2 consecutive failures, got %+v", health)
+	}
+
+	if err := os.Mkdir(root, 0o755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	gatherer.Gather()
+	health = info.Health()
+	if !health.Healthy() || health.LastSuccess.IsZero() || health.LastError == "" {
+		t.Errorf("Expected a recovery that keeps the last error, got %+v", health)
+	}
+}
+
+func TestCollectors_HealthReadErrors(t *testing.T) {
+	cgroupFS := fsys.NewMem()
+	for name, data := range map[string]string{
+		"cgroup.controllers":                          "cpu\n",
+		"system.slice/foo.service/cgroup.controllers": "cpu\n",
+		"system.slice/foo.service/cpu.stat":           "usage_usec 1000\nuser_usec 600\nsystem_usec 400\n",
+	} {
+		if err := cgroupFS.WriteFile(name, []byte(data)); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	cfg := &config.Config{
+		Collectors: config.CollectorsConfig{CPU: config.CPUCollectorConfig{Enabled: true}},
+		Advanced:   config.AdvancedConfig{MaxCgroups: 100},
+	}
+	collectors, registry, gatherer := newTestCollectors(t, cfg, Sources{Cgroup: cgroupFS, Proc: fsys.NewMem()})
+	cpu := collectors["cpu"].(*CPUCollector)
+	registry.MustRegister(cpu)
+
+	// The root cgroup has no cpu.stat here, which is not an error
+	gatherer.Gather()
+	if health := cpu.Health(); !health.Healthy() {
+		t.Fatalf("Expected missing files to keep the collector healthy, got %+v", health)
+	}
+
+	if err := cgroupFS.SetUnreadable("system.slice/foo.service/cpu.stat"); err != nil {
+		t.Fatalf("SetUnreadable failed: %v", err)
+	}
+	gatherer.Gather()
+	health := cpu.Health()
+	if health.Healthy() || !strings.Contains(health.LastError, "cpu.stat") {
+		t.Errorf("Expected an unreadable cpu.stat to degrade the collector, got %+v", health)
+	}
+}
@@ -1,12 +1,12 @@
 package collector
 
 import (
-	"context"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
 	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
 )
 
@@ -16,17 +16,17 @@ type CPUCollector struct {
 	metrics *CollectorMetrics
 
 	// CPU metrics
-	cpuUsageTotal     *prometheus.CounterVec
-	cpuUserTotal      *prometheus.CounterVec
-	cpuSystemTotal    *prometheus.CounterVec
-	cpuThrottledTotal *prometheus.CounterVec
-	cpuPeriodsTotal   *prometheus.CounterVec
-	cpuPressureTotal  *prometheus.CounterVec
+	cpuUsageTotal     *metricDesc
+	cpuUserTotal      *metricDesc
+	cpuSystemTotal    *metricDesc
+	cpuThrottledTotal *metricDesc
+	cpuPeriodsTotal   *metricDesc
+	cpuPressureTotal  *metricDesc
 }
 
 // NewCPUCollector creates a new CPU collector
-func NewCPUCollector(cfg *config.Config, logger *logrus.Logger) *CPUCollector {
-	base := NewBaseCollector("cpu", cfg.Collectors.CPU.Enabled, cfg, logger)
+func NewCPUCollector(cfg *config.Config, sources Sources, logger *logrus.Logger) *CPUCollector {
+	base := NewBaseCollector("cpu", cfg.Collectors.CPU.Enabled, cfg, sources, logger)
 
 	collector := &CPUCollector{
 		BaseCollector: base,
@@ -40,136 +40,99 @@ func NewCPUCollector(cfg *config.Config, logger *logrus.Logger) *CPUCollector {
 }
 
 func (c *CPUCollector) initMetrics() {
-	c.cpuUsageTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: "cgroup",
-			Subsystem: "cpu",
-			Name:      "usage_seconds_total",
-			Help:      "Total CPU time consumed by cgroup",
-		},
-		[]string{"cgroup", "mode"},
-	)
-
-	c.cpuUserTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: "cgroup",
-			Subsystem: "cpu",
-			Name:      "user_seconds_total",
-			Help:      "Total CPU time spent in user mode by cgroup",
-		},
-		[]string{"cgroup"},
-	)
-
-	c.cpuSystemTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: "cgroup",
-			Subsystem: "cpu",
-			Name:      "system_seconds_total",
-			Help:      "Total CPU time spent in system mode by cgroup",
-		},
-		[]string{"cgroup"},
-	)
-
-	c.cpuThrottledTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: "cgroup",
-			Subsystem: "cpu",
-			Name:      "throttled_seconds_total",
-			Help:      "Total time spent throttled by cgroup",
-		},
-		[]string{"cgroup"},
-	)
-
-	c.cpuPeriodsTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: "cgroup",
-			Subsystem: "cpu",
-			Name:      "periods_total",
-			Help:      "Total number of CPU periods by cgroup",
-		},
-		[]string{"cgroup"},
-	)
+	c.cpuUsageTotal = c.newDesc("cpu", "usage_seconds_total",
+		"Total CPU time consumed by cgroup", "mode")
+	c.cpuUserTotal = c.newDesc("cpu", "user_seconds_total",
+		"Total CPU time spent in user mode by cgroup")
+	c.cpuSystemTotal = c.newDesc("cpu", "system_seconds_total",
+		"Total CPU time spent in system mode by cgroup")
+	c.cpuThrottledTotal = c.newDesc("cpu", "throttled_seconds_total",
+		"Total time spent throttled by cgroup")
+	c.cpuPeriodsTotal = c.newDesc("cpu", "periods_total",
+		"Total number of CPU periods by cgroup")
 
 	if c.config.Collectors.CPU.IncludePressure {
-		c.cpuPressureTotal = prometheus.NewCounterVec(
-			prometheus.CounterOpts{
-				Namespace: "cgroup",
-				Subsystem: "cpu",
-				Name:      "pressure_seconds_total",
-				Help:      "Total CPU pressure stall time by cgroup",
-			},
-			[]string{"cgroup", "type"},
-		)
+		c.cpuPressureTotal = c.newDesc("cpu", "pressure_seconds_total",
+			"Total CPU pressure stall time by cgroup", "type")
 	}
 }
 
-// Describe implements prometheus.Collector
-func (c *CPUCollector) Describe(ch chan<- *prometheus.Desc) {
-	if !c.Enabled() {
-		return
-	}
-
-	c.cpuUsageTotal.Describe(ch)
-	c.cpuUserTotal.Describe(ch)
-	c.cpuSystemTotal.Describe(ch)
-	c.cpuThrottledTotal.Describe(ch)
-	c.cpuPeriodsTotal.Describe(ch)
-
-	if c.cpuPressureTotal != nil {
-		c.cpuPressureTotal.Describe(ch)
-	}
-
-	c.metrics.Describe(ch)
-}
+// Describe implements prometheus.Collector. The collector is unchecked
+// because its label names depend on the cgroup label scheme.
+func (c *CPUCollector) Describe(ch chan<- *prometheus.Desc) {}
 
 // Collect implements prometheus.Collector
 func (c *CPUCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch, nil)
+}
+
+// collect exports the metrics of the cgroups in scope (all if nil)
+func (c *CPUCollector) collect(ch chan<- prometheus.Metric, scope Scope) {
 	if !c.Enabled() {
 		return
 	}
 
 	start := time.Now()
 	defer func() {
-		c.metrics.ScrapeDuration.Observe(time.Since(start).Seconds())
+		duration := time.Since(start)
+		c.metrics.ScrapeDuration.Observe(duration.Seconds())
+		c.health.finished(duration)
 		c.metrics.LastScrapeTime.SetToCurrentTime()
+		if scope == nil {
+			c.metrics.Collect(ch)
+		}
 	}()
 
-	// Scan cgroups
-	cgroups, err := c.scanner.Scan(context.Background())
+	cgroups, err := c.cgroups()
 	if err != nil {
 		c.logger.WithError(err).Error("Failed to scan cgroups")
 		c.metrics.ScrapeErrors.Inc()
+		c.health.failure(err)
 		return
 	}
 
 	c.metrics.CgroupsScraped.Set(float64(len(cgroups)))
+	cgroups = scope.filter(cgroups)
 
+	var errs readErrors
 	// Collect metrics from each cgroup
-	for _, cgroup := range cgroups {
-		c.collectCgroupMetrics(cgroup)
+	for _, cg := range cgroups {
+		c.collectCgroupMetrics(ch, cg, &errs)
 	}
+	c.health.scraped(&errs)
+}
 
-	// Collect all metrics
-	c.cpuUsageTotal.Collect(ch)
-	c.cpuUserTotal.Collect(ch)
-	c.cpuSystemTotal.Collect(ch)
-	c.cpuThrottledTotal.Collect(ch)
-	c.cpuPeriodsTotal.Collect(ch)
-
+// collectCgroupMetrics reads cpu.stat and cpu.pressure of a single cgroup
+func (c *CPUCollector) collectCgroupMetrics(ch chan<- prometheus.Metric, cg *cgroup.CgroupInfo, errs *readErrors) {
 	if c.cpuPressureTotal != nil {
-		c.cpuPressureTotal.Collect(ch)
+		c.collectPressure(ch, c.cpuPressureTotal, cg, "cpu.pressure", errs)
 	}
 
-	c.metrics.Collect(ch)
-}
+	if !anyWanted(cg, c.cpuUsageTotal, c.cpuUserTotal, c.cpuSystemTotal, c.cpuThrottledTotal, c.cpuPeriodsTotal) {
+		return
+	}
+	stat, err := cgroup.ReadFlatKeyed(c.fs.Cgroup, cg.Path, "cpu.stat")
+	if err != nil {
+		errs.add(err)
+		c.logger.WithError(err).WithField("cgroup", cg.Path).Debug("Failed to read cpu.stat")
+		return
+	}
+
+	user := float64(stat["user_usec"]) / usecPerSecond
+	system := float64(stat["system_usec"]) / usecPerSecond
 
-func (c *CPUCollector) collectCgroupMetrics(cgroup interface{}) {
-	// This is a stub implementation
-	// In a real implementation, this would read from cgroup v2 files
-	// like cpu.stat, cpu.pressure, etc.
+	c.cpuUsageTotal.send(ch, cg, prometheus.CounterValue, user, "user")
+	c.cpuUsageTotal.send(ch, cg, prometheus.CounterValue, system, "system")
+	c.cpuUserTotal.send(ch, cg, prometheus.CounterValue, user)
+	c.cpuSystemTotal.send(ch, cg, prometheus.CounterValue, system)
 
-	// For now, just set some dummy metrics to make the code compile
-	c.cpuUsageTotal.WithLabelValues("example", "user").Add(0)
-	c.cpuUserTotal.WithLabelValues("example").Add(0)
-	c.cpuSystemTotal.WithLabelValues("example").Add(0)
+	// Throttling statistics are only present when the cpu controller is enabled
+	if throttled, ok := stat["throttled_usec"]; ok {
+		c.cpuThrottledTotal.send(ch, cg, prometheus.CounterValue,
+			float64(throttled)/usecPerSecond)
+	}
+	if periods, ok := stat["nr_periods"]; ok {
+		c.cpuPeriodsTotal.send(ch, cg, prometheus.CounterValue,
+			float64(periods))
+	}
 }
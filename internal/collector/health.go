@@ -0,0 +1,101 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// Health is the scrape health of a collector
+type Health struct {
+	// LastSuccess is the time of the last successful scrape
+	LastSuccess time.Time
+	// LastDuration is how long the last scrape took
+	LastDuration time.Duration
+	// LastError is the error of the last failed scrape, kept after the
+	// collector recovers
+	LastError     string
+	LastErrorTime time.Time
+	// ConsecutiveFailures counts the failed scrapes since the last success
+	ConsecutiveFailures int
+}
+
+// Healthy reports whether the last scrape, if any, succeeded
+func (h Health) Healthy() bool {
+	return h.ConsecutiveFailures == 0
+}
+
+// healthTracker records the outcome of the scrapes of a collector
+type healthTracker struct {
+	mutex  sync.Mutex
+	health Health
+}
+
+func (t *healthTracker) success() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.health.LastSuccess = time.Now()
+	t.health.ConsecutiveFailures = 0
+}
+
+func (t *healthTracker) failure(err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.health.LastError = err.Error()
+	t.health.LastErrorTime = time.Now()
+	t.health.ConsecutiveFailures++
+}
+
+// scraped records the outcome of a scrape whose scan succeeded, a failure
+// if any cgroup file could not be read
+func (t *healthTracker) scraped(errs *readErrors) {
+	if err := errs.err(); err != nil {
+		t.failure(err)
+		return
+	}
+	t.success()
+}
+
+func (t *healthTracker) finished(duration time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.health.LastDuration = duration
+}
+
+// Health returns the scrape health of the collector
+func (bc *BaseCollector) Health() Health {
+	bc.health.mutex.Lock()
+	defer bc.health.mutex.Unlock()
+
+	return bc.health.health
+}
+
+// readErrors counts the failed cgroup file reads of a single scrape. Missing
+// files are not counted: cgroups disappear between the scan and the read,
+// and the root cgroup lacks most controller files.
+type readErrors struct {
+	count int
+	last  error
+}
+
+// add records err if it is not nil and not a missing file
+func (e *readErrors) add(err error) {
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	e.count++
+	e.last = err
+}
+
+// err returns an error summarizing the failed reads, nil if there were none
+func (e *readErrors) err() error {
+	if e.count == 0 {
+		return nil
+	}
+	return fmt.Errorf("failed to read %d cgroup files, last: %w", e.count, e.last)
+}
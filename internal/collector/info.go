@@ -0,0 +1,85 @@
+package collector
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+)
+
+// InfoCollector exports static information about each cgroup, such as the
+// kernel cgroup ID used by eBPF tools, bpftool and perf
+type InfoCollector struct {
+	*BaseCollector
+	metrics *CollectorMetrics
+
+	// Info metrics
+	cgroupInfo *metricDesc
+}
+
+// NewInfoCollector creates a new cgroup info collector
+func NewInfoCollector(cfg *config.Config, sources Sources, logger *logrus.Logger) *InfoCollector {
+	base := NewBaseCollector("info", cfg.Collectors.Info.Enabled, cfg, sources, logger)
+
+	collector := &InfoCollector{
+		BaseCollector: base,
+		metrics:       NewCollectorMetrics("info"),
+	}
+
+	// Initialize metrics
+	collector.initMetrics()
+
+	return collector
+}
+
+func (c *InfoCollector) initMetrics() {
+	c.cgroupInfo = c.newDesc("", "info",
+		"Information about the cgroup; id is the kernel cgroup ID (directory inode)", "id")
+}
+
+// Describe implements prometheus.Collector. The collector is unchecked
+// because its label names depend on the cgroup label scheme.
+func (c *InfoCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector
+func (c *InfoCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch, nil)
+}
+
+// collect exports the metrics of the cgroups in scope (all if nil)
+func (c *InfoCollector) collect(ch chan<- prometheus.Metric, scope Scope) {
+	if !c.Enabled() {
+		return
+	}
+
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		c.metrics.ScrapeDuration.Observe(duration.Seconds())
+		c.health.finished(duration)
+		c.metrics.LastScrapeTime.SetToCurrentTime()
+		if scope == nil {
+			c.metrics.Collect(ch)
+		}
+	}()
+
+	cgroups, err := c.cgroups()
+	if err != nil {
+		c.logger.WithError(err).Error("Failed to scan cgroups")
+		c.metrics.ScrapeErrors.Inc()
+		c.health.failure(err)
+		return
+	}
+
+	c.health.success()
+	c.metrics.CgroupsScraped.Set(float64(len(cgroups)))
+	cgroups = scope.filter(cgroups)
+
+	for _, cg := range cgroups {
+		c.cgroupInfo.send(ch, cg, prometheus.GaugeValue, 1,
+			strconv.FormatUint(cg.ID, 10))
+	}
+}
@@ -1,12 +1,13 @@
 package collector
 
 import (
-	"context"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
 	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
 )
 
@@ -16,16 +17,16 @@ type IOCollector struct {
 	metrics *CollectorMetrics
 
 	// I/O metrics
-	ioReadBytesTotal  *prometheus.CounterVec
-	ioWriteBytesTotal *prometheus.CounterVec
-	ioReadOpsTotal    *prometheus.CounterVec
-	ioWriteOpsTotal   *prometheus.CounterVec
-	ioPressureTotal   *prometheus.CounterVec
+	ioReadBytesTotal  *metricDesc
+	ioWriteBytesTotal *metricDesc
+	ioReadOpsTotal    *metricDesc
+	ioWriteOpsTotal   *metricDesc
+	ioPressureTotal   *metricDesc
 }
 
 // NewIOCollector creates a new I/O collector
-func NewIOCollector(cfg *config.Config, logger *logrus.Logger) *IOCollector {
-	base := NewBaseCollector("io", cfg.Collectors.IO.Enabled, cfg, logger)
+func NewIOCollector(cfg *config.Config, sources Sources, logger *logrus.Logger) *IOCollector {
+	base := NewBaseCollector("io", cfg.Collectors.IO.Enabled, cfg, sources, logger)
 
 	collector := &IOCollector{
 		BaseCollector: base,
@@ -39,123 +40,134 @@ func NewIOCollector(cfg *config.Config, logger *logrus.Logger) *IOCollector {
 }
 
 func (c *IOCollector) initMetrics() {
-	c.ioReadBytesTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: "cgroup",
-			Subsystem: "io",
-			Name:      "read_bytes_total",
-			Help:      "Total bytes read by cgroup",
-		},
-		[]string{"cgroup", "device"},
-	)
-
-	c.ioWriteBytesTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: "cgroup",
-			Subsystem: "io",
-			Name:      "write_bytes_total",
-			Help:      "Total bytes written by cgroup",
-		},
-		[]string{"cgroup", "device"},
-	)
-
-	c.ioReadOpsTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: "cgroup",
-			Subsystem: "io",
-			Name:      "read_operations_total",
-			Help:      "Total read operations by cgroup",
-		},
-		[]string{"cgroup", "device"},
-	)
-
-	c.ioWriteOpsTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: "cgroup",
-			Subsystem: "io",
-			Name:      "write_operations_total",
-			Help:      "Total write operations by cgroup",
-		},
-		[]string{"cgroup", "device"},
-	)
+	c.ioReadBytesTotal = c.newDesc("io", "read_bytes_total",
+		"Total bytes read by cgroup", "device")
+	c.ioWriteBytesTotal = c.newDesc("io", "write_bytes_total",
+		"Total bytes written by cgroup", "device")
+	c.ioReadOpsTotal = c.newDesc("io", "read_operations_total",
+		"Total read operations by cgroup", "device")
+	c.ioWriteOpsTotal = c.newDesc("io", "write_operations_total",
+		"Total write operations by cgroup", "device")
 
 	if c.config.Collectors.IO.IncludePressure {
-		c.ioPressureTotal = prometheus.NewCounterVec(
-			prometheus.CounterOpts{
-				Namespace: "cgroup",
-				Subsystem: "io",
-				Name:      "pressure_seconds_total",
-				Help:      "Total I/O pressure stall time by cgroup",
-			},
-			[]string{"cgroup", "type"},
-		)
+		c.ioPressureTotal = c.newDesc("io", "pressure_seconds_total",
+			"Total I/O pressure stall time by cgroup", "type")
 	}
 }
 
-// Describe implements prometheus.Collector
-func (c *IOCollector) Describe(ch chan<- *prometheus.Desc) {
-	if !c.Enabled() {
-		return
-	}
-
-	c.ioReadBytesTotal.Describe(ch)
-	c.ioWriteBytesTotal.Describe(ch)
-	c.ioReadOpsTotal.Describe(ch)
-	c.ioWriteOpsTotal.Describe(ch)
-
-	if c.ioPressureTotal != nil {
-		c.ioPressureTotal.Describe(ch)
-	}
-
-	c.metrics.Describe(ch)
-}
+// Describe implements prometheus.Collector. The collector is unchecked
+// because its label names depend on the cgroup label scheme.
+func (c *IOCollector) Describe(ch chan<- *prometheus.Desc) {}
 
 // Collect implements prometheus.Collector
 func (c *IOCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch, nil)
+}
+
+// collect exports the metrics of the cgroups in scope (all if nil)
+func (c *IOCollector) collect(ch chan<- prometheus.Metric, scope Scope) {
 	if !c.Enabled() {
 		return
 	}
 
 	start := time.Now()
 	defer func() {
-		c.metrics.ScrapeDuration.Observe(time.Since(start).Seconds())
+		duration := time.Since(start)
+		c.metrics.ScrapeDuration.Observe(duration.Seconds())
+		c.health.finished(duration)
 		c.metrics.LastScrapeTime.SetToCurrentTime()
+		if scope == nil {
+			c.metrics.Collect(ch)
+		}
 	}()
 
-	// Scan cgroups
-	cgroups, err := c.scanner.Scan(context.Background())
+	cgroups, err := c.cgroups()
 	if err != nil {
 		c.logger.WithError(err).Error("Failed to scan cgroups")
 		c.metrics.ScrapeErrors.Inc()
+		c.health.failure(err)
 		return
 	}
 
 	c.metrics.CgroupsScraped.Set(float64(len(cgroups)))
+	cgroups = scope.filter(cgroups)
+
+	devices := c.readDeviceNames()
 
+	var errs readErrors
 	// Collect metrics from each cgroup
-	for _, cgroup := range cgroups {
-		c.collectCgroupMetrics(cgroup)
+	for _, cg := range cgroups {
+		c.collectCgroupMetrics(ch, cg, devices, &errs)
 	}
+	c.health.scraped(&errs)
+}
 
-	// Collect all metrics
-	c.ioReadBytesTotal.Collect(ch)
-	c.ioWriteBytesTotal.Collect(ch)
-	c.ioReadOpsTotal.Collect(ch)
-	c.ioWriteOpsTotal.Collect(ch)
-
+// collectCgroupMetrics reads io.stat and io.pressure of a single cgroup
+func (c *IOCollector) collectCgroupMetrics(ch chan<- prometheus.Metric, cg *cgroup.CgroupInfo, devices map[string]string, errs *readErrors) {
 	if c.ioPressureTotal != nil {
-		c.ioPressureTotal.Collect(ch)
+		c.collectPressure(ch, c.ioPressureTotal, cg, "io.pressure", errs)
+	}
+
+	if !anyWanted(cg, c.ioReadBytesTotal, c.ioWriteBytesTotal, c.ioReadOpsTotal, c.ioWriteOpsTotal) {
+		return
+	}
+	stat, err := cgroup.ReadNestedKeyed(c.fs.Cgroup, cg.Path, "io.stat")
+	if err != nil {
+		errs.add(err)
+		c.logger.WithError(err).WithField("cgroup", cg.Path).Debug("Failed to read io.stat")
+		return
 	}
 
-	c.metrics.Collect(ch)
+	for majMin, values := range stat {
+		device := majMin
+		if name, ok := devices[majMin]; ok {
+			device = name
+		}
+		if !c.deviceSelected(majMin, device) {
+			continue
+		}
+
+		c.ioReadBytesTotal.send(ch, cg, prometheus.CounterValue, float64(values["rbytes"]), device)
+		c.ioWriteBytesTotal.send(ch, cg, prometheus.CounterValue, float64(values["wbytes"]), device)
+		c.ioReadOpsTotal.send(ch, cg, prometheus.CounterValue, float64(values["rios"]), device)
+		c.ioWriteOpsTotal.send(ch, cg, prometheus.CounterValue, float64(values["wios"]), device)
+	}
 }
 
-func (c *IOCollector) collectCgroupMetrics(cgroup interface{}) {
-	// This is a stub implementation
-	// In a real implementation, this would read from cgroup v2 files
-	// like io.stat, io.pressure, etc.
+// deviceSelected reports whether a device passes the configured device filter,
+// which may list either device names or major:minor numbers
+func (c *IOCollector) deviceSelected(majMin, name string) bool {
+	filter := c.config.Collectors.IO.Devices
+	if len(filter) == 0 {
+		return true
+	}
 
-	// For now, just set some dummy metrics to make the code compile
-	c.ioReadBytesTotal.WithLabelValues("example", "sda").Add(0)
-	c.ioWriteBytesTotal.WithLabelValues("example", "sda").Add(0)
+	for _, device := range filter {
+		if device == majMin || device == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readDeviceNames maps major:minor numbers to block device names using
+// /proc/partitions
+func (c *IOCollector) readDeviceNames() map[string]string {
+	devices := make(map[string]string)
+
+	data, err := c.fs.Proc.ReadFile("partitions")
+	if err != nil {
+		c.logger.WithError(err).Debug("Failed to read /proc/partitions")
+		return devices
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		// major minor #blocks name
+		fields := strings.Fields(line)
+		if len(fields) != 4 || fields[0] == "major" {
+			continue
+		}
+		devices[fields[0]+":"+fields[1]] = fields[3]
+	}
+	return devices
 }
@@ -1,12 +1,13 @@
 package collector
 
 import (
-	"context"
+	"math"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
 	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
 )
 
@@ -16,18 +17,18 @@ type MemoryCollector struct {
 	metrics *CollectorMetrics
 
 	// Memory metrics
-	memoryUsageBytes     *prometheus.GaugeVec
-	memoryLimitBytes     *prometheus.GaugeVec
-	memoryCacheBytes     *prometheus.GaugeVec
-	memoryRSSBytes       *prometheus.GaugeVec
-	memorySwapUsageBytes *prometheus.GaugeVec
-	memoryOOMEvents      *prometheus.CounterVec
-	memoryPressureTotal  *prometheus.CounterVec
+	memoryUsageBytes     *metricDesc
+	memoryLimitBytes     *metricDesc
+	memoryCacheBytes     *metricDesc
+	memoryRSSBytes       *metricDesc
+	memorySwapUsageBytes *metricDesc
+	memoryOOMEvents      *metricDesc
+	memoryPressureTotal  *metricDesc
 }
 
 // NewMemoryCollector creates a new memory collector
-func NewMemoryCollector(cfg *config.Config, logger *logrus.Logger) *MemoryCollector {
-	base := NewBaseCollector("memory", cfg.Collectors.Memory.Enabled, cfg, logger)
+func NewMemoryCollector(cfg *config.Config, sources Sources, logger *logrus.Logger) *MemoryCollector {
+	base := NewBaseCollector("memory", cfg.Collectors.Memory.Enabled, cfg, sources, logger)
 
 	collector := &MemoryCollector{
 		BaseCollector: base,
@@ -41,156 +42,121 @@ func NewMemoryCollector(cfg *config.Config, logger *logrus.Logger) *MemoryCollec
 }
 
 func (c *MemoryCollector) initMetrics() {
-	c.memoryUsageBytes = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "cgroup",
-			Subsystem: "memory",
-			Name:      "usage_bytes",
-			Help:      "Current memory usage by cgroup",
-		},
-		[]string{"cgroup"},
-	)
-
-	c.memoryLimitBytes = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "cgroup",
-			Subsystem: "memory",
-			Name:      "limit_bytes",
-			Help:      "Memory limit for cgroup",
-		},
-		[]string{"cgroup"},
-	)
-
-	c.memoryCacheBytes = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "cgroup",
-			Subsystem: "memory",
-			Name:      "cache_bytes",
-			Help:      "Cache memory usage by cgroup",
-		},
-		[]string{"cgroup"},
-	)
-
-	c.memoryRSSBytes = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "cgroup",
-			Subsystem: "memory",
-			Name:      "rss_bytes",
-			Help:      "RSS memory usage by cgroup",
-		},
-		[]string{"cgroup"},
-	)
+	c.memoryUsageBytes = c.newDesc("memory", "usage_bytes",
+		"Current memory usage by cgroup")
+	c.memoryLimitBytes = c.newDesc("memory", "limit_bytes",
+		"Memory limit for cgroup")
+	c.memoryCacheBytes = c.newDesc("memory", "cache_bytes",
+		"Cache memory usage by cgroup")
+	c.memoryRSSBytes = c.newDesc("memory", "rss_bytes",
+		"RSS memory usage by cgroup")
 
 	if c.config.Collectors.Memory.IncludeSwap {
-		c.memorySwapUsageBytes = prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Namespace: "cgroup",
-				Subsystem: "memory",
-				Name:      "swap_usage_bytes",
-				Help:      "Swap usage by cgroup",
-			},
-			[]string{"cgroup"},
-		)
+		c.memorySwapUsageBytes = c.newDesc("memory", "swap_usage_bytes",
+			"Swap usage by cgroup")
 	}
 
-	c.memoryOOMEvents = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: "cgroup",
-			Subsystem: "memory",
-			Name:      "oom_events_total",
-			Help:      "Total number of OOM events by cgroup",
-		},
-		[]string{"cgroup"},
-	)
+	c.memoryOOMEvents = c.newDesc("memory", "oom_events_total",
+		"Total number of OOM events by cgroup")
 
 	if c.config.Collectors.Memory.IncludePressure {
-		c.memoryPressureTotal = prometheus.NewCounterVec(
-			prometheus.CounterOpts{
-				Namespace: "cgroup",
-				Subsystem: "memory",
-				Name:      "pressure_seconds_total",
-				Help:      "Total memory pressure stall time by cgroup",
-			},
-			[]string{"cgroup", "type"},
-		)
+		c.memoryPressureTotal = c.newDesc("memory", "pressure_seconds_total",
+			"Total memory pressure stall time by cgroup", "type")
 	}
 }
 
-// Describe implements prometheus.Collector
-func (c *MemoryCollector) Describe(ch chan<- *prometheus.Desc) {
-	if !c.Enabled() {
-		return
-	}
-
-	c.memoryUsageBytes.Describe(ch)
-	c.memoryLimitBytes.Describe(ch)
-	c.memoryCacheBytes.Describe(ch)
-	c.memoryRSSBytes.Describe(ch)
-	c.memoryOOMEvents.Describe(ch)
-
-	if c.memorySwapUsageBytes != nil {
-		c.memorySwapUsageBytes.Describe(ch)
-	}
-
-	if c.memoryPressureTotal != nil {
-		c.memoryPressureTotal.Describe(ch)
-	}
-
-	c.metrics.Describe(ch)
-}
+// Describe implements prometheus.Collector. The collector is unchecked
+// because its label names depend on the cgroup label scheme.
+func (c *MemoryCollector) Describe(ch chan<- *prometheus.Desc) {}
 
 // Collect implements prometheus.Collector
 func (c *MemoryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch, nil)
+}
+
+// collect exports the metrics of the cgroups in scope (all if nil)
+func (c *MemoryCollector) collect(ch chan<- prometheus.Metric, scope Scope) {
 	if !c.Enabled() {
 		return
 	}
 
 	start := time.Now()
 	defer func() {
-		c.metrics.ScrapeDuration.Observe(time.Since(start).Seconds())
+		duration := time.Since(start)
+		c.metrics.ScrapeDuration.Observe(duration.Seconds())
+		c.health.finished(duration)
 		c.metrics.LastScrapeTime.SetToCurrentTime()
+		if scope == nil {
+			c.metrics.Collect(ch)
+		}
 	}()
 
-	// Scan cgroups
-	cgroups, err := c.scanner.Scan(context.Background())
+	cgroups, err := c.cgroups()
 	if err != nil {
 		c.logger.WithError(err).Error("Failed to scan cgroups")
 		c.metrics.ScrapeErrors.Inc()
+		c.health.failure(err)
 		return
 	}
 
 	c.metrics.CgroupsScraped.Set(float64(len(cgroups)))
+	cgroups = scope.filter(cgroups)
 
+	var errs readErrors
 	// Collect metrics from each cgroup
-	for _, cgroup := range cgroups {
-		c.collectCgroupMetrics(cgroup)
+	for _, cg := range cgroups {
+		c.collectCgroupMetrics(ch, cg, &errs)
 	}
+	c.health.scraped(&errs)
+}
 
-	// Collect all metrics
-	c.memoryUsageBytes.Collect(ch)
-	c.memoryLimitBytes.Collect(ch)
-	c.memoryCacheBytes.Collect(ch)
-	c.memoryRSSBytes.Collect(ch)
-	c.memoryOOMEvents.Collect(ch)
+// collectCgroupMetrics reads the memory.* files of a single cgroup. The root
+// cgroup has none of them, so missing files are skipped; other read errors
+// degrade the collector.
+func (c *MemoryCollector) collectCgroupMetrics(ch chan<- prometheus.Metric, cg *cgroup.CgroupInfo, errs *readErrors) {
+	if c.memoryUsageBytes.wanted(cg) {
+		if usage, err := cgroup.ReadUint(c.fs.Cgroup, cg.Path, "memory.current"); err == nil {
+			c.memoryUsageBytes.send(ch, cg, prometheus.GaugeValue, float64(usage))
+		} else {
+			errs.add(err)
+		}
+	}
 
-	if c.memorySwapUsageBytes != nil {
-		c.memorySwapUsageBytes.Collect(ch)
+	// An unlimited cgroup ("max") has no meaningful limit to export
+	if c.memoryLimitBytes.wanted(cg) {
+		if limit, err := cgroup.ReadUint(c.fs.Cgroup, cg.Path, "memory.max"); err == nil && limit != math.MaxUint64 {
+			c.memoryLimitBytes.send(ch, cg, prometheus.GaugeValue, float64(limit))
+		} else {
+			errs.add(err)
+		}
 	}
 
-	if c.memoryPressureTotal != nil {
-		c.memoryPressureTotal.Collect(ch)
+	if anyWanted(cg, c.memoryCacheBytes, c.memoryRSSBytes) {
+		if stat, err := cgroup.ReadFlatKeyed(c.fs.Cgroup, cg.Path, "memory.stat"); err == nil {
+			c.memoryCacheBytes.send(ch, cg, prometheus.GaugeValue, float64(stat["file"]))
+			c.memoryRSSBytes.send(ch, cg, prometheus.GaugeValue, float64(stat["anon"]))
+		} else {
+			errs.add(err)
+		}
 	}
 
-	c.metrics.Collect(ch)
-}
+	if anyWanted(cg, c.memorySwapUsageBytes) {
+		if swap, err := cgroup.ReadUint(c.fs.Cgroup, cg.Path, "memory.swap.current"); err == nil {
+			c.memorySwapUsageBytes.send(ch, cg, prometheus.GaugeValue, float64(swap))
+		} else {
+			errs.add(err)
+		}
+	}
 
-func (c *MemoryCollector) collectCgroupMetrics(cgroup interface{}) {
-	// This is a stub implementation
-	// In a real implementation, this would read from cgroup v2 files
-	// like memory.current, memory.max, memory.stat, etc.
+	if c.memoryOOMEvents.wanted(cg) {
+		if events, err := cgroup.ReadFlatKeyed(c.fs.Cgroup, cg.Path, "memory.events"); err == nil {
+			c.memoryOOMEvents.send(ch, cg, prometheus.CounterValue, float64(events["oom"]))
+		} else {
+			errs.add(err)
+		}
+	}
 
-	// For now, just set some dummy metrics to make the code compile
-	c.memoryUsageBytes.WithLabelValues("example").Set(0)
-	c.memoryLimitBytes.WithLabelValues("example").Set(0)
-	c.memoryCacheBytes.WithLabelValues("example").Set(0)
+	if c.memoryPressureTotal != nil {
+		c.collectPressure(ch, c.memoryPressureTotal, cg, "memory.pressure", errs)
+	}
 }
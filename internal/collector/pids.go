@@ -1,12 +1,14 @@
 package collector
 
 import (
-	"context"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
 	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
 )
 
@@ -16,15 +18,15 @@ type PIDsCollector struct {
 	metrics *CollectorMetrics
 
 	// PIDs metrics
-	processesCount    *prometheus.GaugeVec
-	processesRunning  *prometheus.GaugeVec
-	processesSleeping *prometheus.GaugeVec
-	processesZombie   *prometheus.GaugeVec
+	processesCount    *metricDesc
+	processesRunning  *metricDesc
+	processesSleeping *metricDesc
+	processesZombie   *metricDesc
 }
 
 // NewPIDsCollector creates a new PIDs collector
-func NewPIDsCollector(cfg *config.Config, logger *logrus.Logger) *PIDsCollector {
-	base := NewBaseCollector("pids", cfg.Collectors.PIDs.Enabled, cfg, logger)
+func NewPIDsCollector(cfg *config.Config, sources Sources, logger *logrus.Logger) *PIDsCollector {
+	base := NewBaseCollector("pids", cfg.Collectors.PIDs.Enabled, cfg, sources, logger)
 
 	collector := &PIDsCollector{
 		BaseCollector: base,
@@ -38,103 +40,112 @@ func NewPIDsCollector(cfg *config.Config, logger *logrus.Logger) *PIDsCollector
 }
 
 func (c *PIDsCollector) initMetrics() {
-	c.processesCount = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "cgroup",
-			Subsystem: "processes",
-			Name:      "count",
-			Help:      "Number of processes in cgroup",
-		},
-		[]string{"cgroup"},
-	)
-
-	c.processesRunning = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "cgroup",
-			Subsystem: "processes",
-			Name:      "running",
-			Help:      "Number of running processes in cgroup",
-		},
-		[]string{"cgroup"},
-	)
-
-	c.processesSleeping = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "cgroup",
-			Subsystem: "processes",
-			Name:      "sleeping",
-			Help:      "Number of sleeping processes in cgroup",
-		},
-		[]string{"cgroup"},
-	)
-
-	c.processesZombie = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "cgroup",
-			Subsystem: "processes",
-			Name:      "zombie",
-			Help:      "Number of zombie processes in cgroup",
-		},
-		[]string{"cgroup"},
-	)
+	c.processesCount = c.newDesc("processes", "count",
+		"Number of processes in cgroup")
+	c.processesRunning = c.newDesc("processes", "running",
+		"Number of running processes in cgroup")
+	c.processesSleeping = c.newDesc("processes", "sleeping",
+		"Number of sleeping processes in cgroup")
+	c.processesZombie = c.newDesc("processes", "zombie",
+		"Number of zombie processes in cgroup")
 }
 
-// Describe implements prometheus.Collector
-func (c *PIDsCollector) Describe(ch chan<- *prometheus.Desc) {
-	if !c.Enabled() {
-		return
-	}
-
-	c.processesCount.Describe(ch)
-	c.processesRunning.Describe(ch)
-	c.processesSleeping.Describe(ch)
-	c.processesZombie.Describe(ch)
-
-	c.metrics.Describe(ch)
-}
+// Describe implements prometheus.Collector. The collector is unchecked
+// because its label names depend on the cgroup label scheme.
+func (c *PIDsCollector) Describe(ch chan<- *prometheus.Desc) {}
 
 // Collect implements prometheus.Collector
 func (c *PIDsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch, nil)
+}
+
+// collect exports the metrics of the cgroups in scope (all if nil)
+func (c *PIDsCollector) collect(ch chan<- prometheus.Metric, scope Scope) {
 	if !c.Enabled() {
 		return
 	}
 
 	start := time.Now()
 	defer func() {
-		c.metrics.ScrapeDuration.Observe(time.Since(start).Seconds())
+		duration := time.Since(start)
+		c.metrics.ScrapeDuration.Observe(duration.Seconds())
+		c.health.finished(duration)
 		c.metrics.LastScrapeTime.SetToCurrentTime()
+		if scope == nil {
+			c.metrics.Collect(ch)
+		}
 	}()
 
-	// Scan cgroups
-	cgroups, err := c.scanner.Scan(context.Background())
+	cgroups, err := c.cgroups()
 	if err != nil {
 		c.logger.WithError(err).Error("Failed to scan cgroups")
 		c.metrics.ScrapeErrors.Inc()
+		c.health.failure(err)
 		return
 	}
 
 	c.metrics.CgroupsScraped.Set(float64(len(cgroups)))
+	cgroups = scope.filter(cgroups)
 
+	var errs readErrors
 	// Collect metrics from each cgroup
-	for _, cgroup := range cgroups {
-		c.collectCgroupMetrics(cgroup)
+	for _, cg := range cgroups {
+		c.collectCgroupMetrics(ch, cg, &errs)
+	}
+	c.health.scraped(&errs)
+}
+
+// collectCgroupMetrics counts the processes of a single cgroup by state
+func (c *PIDsCollector) collectCgroupMetrics(ch chan<- prometheus.Metric, cg *cgroup.CgroupInfo, errs *readErrors) {
+	if !anyWanted(cg, c.processesCount, c.processesRunning, c.processesSleeping, c.processesZombie) {
+		return
 	}
 
-	// Collect all metrics
-	c.processesCount.Collect(ch)
-	c.processesRunning.Collect(ch)
-	c.processesSleeping.Collect(ch)
-	c.processesZombie.Collect(ch)
+	pids, err := cgroup.ReadProcs(c.fs.Cgroup, cg.Path)
+	if err != nil {
+		errs.add(err)
+		c.logger.WithError(err).WithField("cgroup", cg.Path).Debug("Failed to read cgroup.procs")
+		return
+	}
+
+	c.processesCount.send(ch, cg, prometheus.GaugeValue, float64(len(pids)))
+
+	// Reading the state of every process is the expensive part
+	if !anyWanted(cg, c.processesRunning, c.processesSleeping, c.processesZombie) {
+		return
+	}
+
+	var running, sleeping, zombie int
+	for _, pid := range pids {
+		switch c.processState(pid) {
+		case 'R':
+			running++
+		case 'S', 'D', 'I':
+			sleeping++
+		case 'Z':
+			zombie++
+		}
+	}
 
-	c.metrics.Collect(ch)
+	c.processesRunning.send(ch, cg, prometheus.GaugeValue, float64(running))
+	c.processesSleeping.send(ch, cg, prometheus.GaugeValue, float64(sleeping))
+	c.processesZombie.send(ch, cg, prometheus.GaugeValue, float64(zombie))
 }
 
-func (c *PIDsCollector) collectCgroupMetrics(cgroup interface{}) {
-	// This is a stub implementation
-	// In a real implementation, this would read from cgroup v2 files
-	// like cgroup.procs, pids.current, etc.
+// processState returns the state character of /proc/<pid>/stat, or 0 if the
+// process has exited in the meantime
+func (c *PIDsCollector) processState(pid int) byte {
+	data, err := c.fs.Proc.ReadFile(strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0
+	}
 
-	// For now, just set some dummy metrics to make the code compile
-	c.processesCount.WithLabelValues("example").Set(0)
-	c.processesRunning.WithLabelValues("example").Set(0)
+	// The command name may contain spaces and parentheses, so the state is
+	// located relative to the last closing parenthesis.
+	stat := string(data)
+	idx := strings.LastIndexByte(stat, ')')
+	if idx < 0 || idx+2 >= len(stat) {
+		return 0
+	}
+	return stat[idx+2]
 }
@@ -0,0 +1,57 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+)
+
+// Scope restricts a collection to the cgroups whose paths it accepts, e.g.
+// to the subtrees a tenant may see
+type Scope func(cgroupPath string) bool
+
+// filter returns the cgroups in scope; a nil scope accepts all cgroups
+func (s Scope) filter(cgroups []*cgroup.CgroupInfo) []*cgroup.CgroupInfo {
+	if s == nil {
+		return cgroups
+	}
+
+	var filtered []*cgroup.CgroupInfo
+	for _, cg := range cgroups {
+		if s(cg.Path) {
+			filtered = append(filtered, cg)
+		}
+	}
+	return filtered
+}
+
+// scopeCollector is implemented by the collectors that can restrict a
+// collection to a scope
+type scopeCollector interface {
+	collect(ch chan<- prometheus.Metric, scope Scope)
+}
+
+// scopedCollector collects a collector within a scope
+type scopedCollector struct {
+	collector scopeCollector
+	scope     Scope
+}
+
+// Scoped returns a view of a collector exporting only the cgroups in scope.
+// The collector's own scrape metrics describe the whole host and are left
+// out, as is everything from collectors that do not support scoping.
+func Scoped(c Collector, scope Scope) prometheus.Collector {
+	sc, _ := c.(scopeCollector)
+	return &scopedCollector{collector: sc, scope: scope}
+}
+
+// Describe implements prometheus.Collector. Like the collectors themselves,
+// the view is unchecked.
+func (c *scopedCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector
+func (c *scopedCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.collector != nil {
+		c.collector.collect(ch, c.scope)
+	}
+}
@@ -2,30 +2,140 @@ package config
 
 import (
 	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/relabel"
 )
 
 // Config represents the application configuration
 type Config struct {
-	Web        WebConfig        `mapstructure:"web"`
-	Cgroup     CgroupConfig     `mapstructure:"cgroup"`
+	Web    WebConfig    `mapstructure:"web"`
+	Cgroup CgroupConfig `mapstructure:"cgroup"`
+	Proc   ProcConfig   `mapstructure:"proc"`
+	Probe  ProbeConfig  `mapstructure:"probe"`
+	// RemoteWrite pushes the metrics to a remote_write endpoint
+	RemoteWrite RemoteWriteConfig `mapstructure:"remote_write"`
+	// OTLP exports the metrics to an OpenTelemetry Collector
+	OTLP OTLPConfig `mapstructure:"otlp"`
+	// Output writes the metrics to a file instead of serving them
+	Output     OutputConfig     `mapstructure:"output"`
 	Collectors CollectorsConfig `mapstructure:"collectors"`
-	Logging    LoggingConfig    `mapstructure:"logging"`
-	Advanced   AdvancedConfig   `mapstructure:"advanced"`
+	Enrichers  EnrichersConfig  `mapstructure:"enrichers"`
+	// RelabelConfigs rewrite or filter the labels of discovered cgroups
+	// after enrichment; the cgroup path is available as __path__
+	RelabelConfigs []relabel.Config `mapstructure:"relabel_configs"`
+	// MetricRelabelConfigs filter or rewrite individual series before
+	// exposition; the metric name is available as __name__
+	MetricRelabelConfigs []relabel.Config `mapstructure:"metric_relabel_configs"`
+	Logging              LoggingConfig    `mapstructure:"logging"`
+	Advanced             AdvancedConfig   `mapstructure:"advanced"`
 }
 
 // WebConfig contains web server configuration
 type WebConfig struct {
 	ListenAddress string `mapstructure:"listen_address"`
 	TelemetryPath string `mapstructure:"telemetry_path"`
+	// ConfigFile is an exporter-toolkit compatible web configuration file
+	// enabling TLS and basic auth
+	ConfigFile string `mapstructure:"config_file"`
+	// OpenMetricsCreatedSamples adds _created samples of counters to the
+	// OpenMetrics text format. Prometheus versions without created
+	// timestamp support ingest them as separate series.
+	OpenMetricsCreatedSamples bool `mapstructure:"openmetrics_created_samples"`
+	// DisableMetricsEndpoint stops serving the telemetry path, for setups
+	// that only push the metrics
+	DisableMetricsEndpoint bool `mapstructure:"disable_metrics_endpoint"`
 }
 
 // CgroupConfig contains cgroup-related configuration
 type CgroupConfig struct {
 	Path            string        `mapstructure:"path"`
 	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+	// LabelScheme selects the identifying labels of cgroup series: "name"
+	// (a dot-joined cgroup label) or "hierarchical" (path, parent, depth,
+	// leaf and type labels)
+	LabelScheme string `mapstructure:"label_scheme"`
+	// Xattrs is an allow-list of extended attributes of cgroup directories
+	// exported as xattr_<name> labels; "user.*" allows a whole prefix
+	Xattrs []string `mapstructure:"xattrs"`
+}
+
+// ProcConfig contains procfs-related configuration
+type ProcConfig struct {
+	Path string `mapstructure:"path"`
+}
+
+// ProbeConfig contains configuration of the /probe endpoint
+type ProbeConfig struct {
+	// AllowedRoots are the cgroupfs roots /probe?target= may collect from,
+	// e.g. the cgroupfs of a nested container. Entries may be shell patterns.
+	AllowedRoots []string `mapstructure:"allowed_roots"`
+}
+
+// RemoteWriteConfig contains configuration of the remote_write push mode,
+// which is disabled while URL is empty
+type RemoteWriteConfig struct {
+	URL      string        `mapstructure:"url"`
+	Interval time.Duration `mapstructure:"interval"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+	// ExternalLabels are added to every pushed series that does not have
+	// them, e.g. to identify the node
+	ExternalLabels map[string]string `mapstructure:"external_labels"`
+	BasicAuth      BasicAuthConfig   `mapstructure:"basic_auth"`
+	BearerToken    string            `mapstructure:"bearer_token" secret:"true"`
+	Queue          QueueConfig       `mapstructure:"queue"`
+}
+
+// BasicAuthConfig contains HTTP basic auth credentials
+type BasicAuthConfig struct {
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password" secret:"true"`
+}
+
+// QueueConfig contains configuration of the in-memory send queue of the
+// remote_write push mode
+type QueueConfig struct {
+	// Capacity is the number of batches held while the endpoint is
+	// unavailable; the oldest batch is dropped when the queue is full
+	Capacity          int `mapstructure:"capacity"`
+	MaxSamplesPerSend int `mapstructure:"max_samples_per_send"`
+	// MaxRetries is the number of retries of a batch failing with a network
+	// error, a 5xx or a 429 status before it is dropped
+	MaxRetries int           `mapstructure:"max_retries"`
+	MinBackoff time.Duration `mapstructure:"min_backoff"`
+	MaxBackoff time.Duration `mapstructure:"max_backoff"`
+}
+
+// OTLPConfig contains configuration of the OTLP metrics export, which is
+// disabled while Endpoint is empty
+type OTLPConfig struct {
+	// Endpoint is the URL of an OTLP/HTTP metrics endpoint, e.g.
+	// http://collector:4318/v1/metrics, or host:port with the grpc protocol
+	Endpoint string `mapstructure:"endpoint"`
+	// Protocol is "http/protobuf" or "grpc"
+	Protocol string `mapstructure:"protocol"`
+	// Insecure disables TLS with the grpc protocol
+	Insecure bool              `mapstructure:"insecure"`
+	Headers  map[string]string `mapstructure:"headers" secret:"true"`
+	Interval time.Duration     `mapstructure:"interval"`
+	Timeout  time.Duration     `mapstructure:"timeout"`
+	// ResourceAttributes are added to every exported resource, e.g.
+	// deployment.environment
+	ResourceAttributes map[string]string `mapstructure:"resource_attributes"`
+}
+
+// OutputConfig contains configuration of the textfile output mode, which is
+// disabled while Textfile is empty
+type OutputConfig struct {
+	// Textfile is the path of a .prom file for the textfile collector of
+	// node_exporter
+	Textfile string        `mapstructure:"textfile"`
+	Interval time.Duration `mapstructure:"interval"`
 }
 
 // CollectorsConfig contains collector configuration
@@ -34,6 +144,7 @@ type CollectorsConfig struct {
 	Memory MemoryCollectorConfig `mapstructure:"memory"`
 	IO     IOCollectorConfig     `mapstructure:"io"`
 	PIDs   PIDsCollectorConfig   `mapstructure:"pids"`
+	Info   InfoCollectorConfig   `mapstructure:"info"`
 }
 
 // CPUCollectorConfig contains CPU collector configuration
@@ -61,6 +172,107 @@ type PIDsCollectorConfig struct {
 	Enabled bool `mapstructure:"enabled"`
 }
 
+// InfoCollectorConfig contains cgroup info collector configuration
+type InfoCollectorConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// EnrichersConfig contains cgroup enricher configuration
+type EnrichersConfig struct {
+	Systemd    SystemdEnricherConfig    `mapstructure:"systemd"`
+	Docker     DockerEnricherConfig     `mapstructure:"docker"`
+	CRI        CRIEnricherConfig        `mapstructure:"cri"`
+	Kubernetes KubernetesEnricherConfig `mapstructure:"kubernetes"`
+	Kubelet    KubeletEnricherConfig    `mapstructure:"kubelet"`
+	Podman     PodmanEnricherConfig     `mapstructure:"podman"`
+	LXC        LXCEnricherConfig        `mapstructure:"lxc"`
+	Nspawn     NspawnEnricherConfig     `mapstructure:"nspawn"`
+	Slurm      SlurmEnricherConfig      `mapstructure:"slurm"`
+	Nomad      NomadEnricherConfig      `mapstructure:"nomad"`
+}
+
+// SystemdEnricherConfig contains systemd unit enricher configuration
+type SystemdEnricherConfig struct {
+	Enabled    bool   `mapstructure:"enabled"`
+	PasswdPath string `mapstructure:"passwd_path"`
+}
+
+// DockerEnricherConfig contains Docker container enricher configuration
+type DockerEnricherConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// QueryAPI enables container metadata lookups through the Engine API;
+	// without it only container_id is derived from the cgroup path
+	QueryAPI        bool          `mapstructure:"query_api"`
+	Socket          string        `mapstructure:"socket"`
+	Labels          []string      `mapstructure:"labels"`
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+}
+
+// CRIEnricherConfig contains CRI (containerd, CRI-O) container enricher configuration
+type CRIEnricherConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Socket is the CRI runtime socket; when empty the containerd and CRI-O
+	// default sockets are probed
+	Socket          string        `mapstructure:"socket"`
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+}
+
+// KubernetesEnricherConfig contains Kubernetes pod enricher configuration
+type KubernetesEnricherConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// LogPath is the kubelet log directory holding pods/ and containers/
+	LogPath         string        `mapstructure:"log_path"`
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+}
+
+// KubeletEnricherConfig contains kubelet pod metadata enricher configuration
+type KubeletEnricherConfig struct {
+	Enabled            bool   `mapstructure:"enabled"`
+	URL                string `mapstructure:"url"`
+	TokenPath          string `mapstructure:"token_path"`
+	CAPath             string `mapstructure:"ca_path"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+	// Labels and Annotations are the pod metadata keys exported as labels
+	Labels          []string      `mapstructure:"labels"`
+	Annotations     []string      `mapstructure:"annotations"`
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+}
+
+// PodmanEnricherConfig contains Podman container enricher configuration
+type PodmanEnricherConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// QueryAPI enables container metadata lookups through the Podman socket;
+	// without it container_id and the short ID as container_name are derived
+	// from the cgroup path
+	QueryAPI        bool          `mapstructure:"query_api"`
+	Socket          string        `mapstructure:"socket"`
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+}
+
+// LXCEnricherConfig contains LXC/LXD container enricher configuration
+type LXCEnricherConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// NspawnEnricherConfig contains systemd-nspawn container enricher configuration
+type NspawnEnricherConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// SlurmEnricherConfig contains Slurm job enricher configuration
+type SlurmEnricherConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// NomadEnricherConfig contains Nomad task enricher configuration
+type NomadEnricherConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
 // LoggingConfig contains logging configuration
 type LoggingConfig struct {
 	Level  string `mapstructure:"level"`
@@ -105,10 +317,42 @@ func setDefaults() {
 	// Web defaults
 	viper.SetDefault("web.listen_address", ":9753")
 	viper.SetDefault("web.telemetry_path", "/metrics")
+	viper.SetDefault("web.config_file", "")
+	viper.SetDefault("web.openmetrics_created_samples", false)
+	viper.SetDefault("web.disable_metrics_endpoint", false)
 
 	// Cgroup defaults
 	viper.SetDefault("cgroup.path", "/sys/fs/cgroup")
 	viper.SetDefault("cgroup.refresh_interval", "15s")
+	viper.SetDefault("cgroup.label_scheme", "name")
+	viper.SetDefault("cgroup.xattrs", []string{})
+
+	// Proc defaults
+	viper.SetDefault("proc.path", "/proc")
+
+	// Probe defaults
+	viper.SetDefault("probe.allowed_roots", []string{})
+
+	// Remote write defaults
+	viper.SetDefault("remote_write.url", "")
+	viper.SetDefault("remote_write.interval", "30s")
+	viper.SetDefault("remote_write.timeout", "10s")
+	viper.SetDefault("remote_write.queue.capacity", 10)
+	viper.SetDefault("remote_write.queue.max_samples_per_send", 2000)
+	viper.SetDefault("remote_write.queue.max_retries", 5)
+	viper.SetDefault("remote_write.queue.min_backoff", "30ms")
+	viper.SetDefault("remote_write.queue.max_backoff", "5s")
+
+	// OTLP defaults
+	viper.SetDefault("otlp.endpoint", "")
+	viper.SetDefault("otlp.protocol", "http/protobuf")
+	viper.SetDefault("otlp.insecure", false)
+	viper.SetDefault("otlp.interval", "30s")
+	viper.SetDefault("otlp.timeout", "10s")
+
+	// Output defaults
+	viper.SetDefault("output.textfile", "")
+	viper.SetDefault("output.interval", "30s")
 
 	// Collector defaults
 	viper.SetDefault("collectors.cpu.enabled", true)
@@ -120,6 +364,42 @@ func setDefaults() {
 	viper.SetDefault("collectors.io.include_pressure", true)
 	viper.SetDefault("collectors.io.devices", []string{})
 	viper.SetDefault("collectors.pids.enabled", true)
+	viper.SetDefault("collectors.info.enabled", true)
+
+	// Enricher defaults
+	viper.SetDefault("enrichers.systemd.enabled", false)
+	viper.SetDefault("enrichers.systemd.passwd_path", "/etc/passwd")
+	viper.SetDefault("enrichers.docker.enabled", false)
+	viper.SetDefault("enrichers.docker.query_api", false)
+	viper.SetDefault("enrichers.docker.socket", "/var/run/docker.sock")
+	viper.SetDefault("enrichers.docker.labels", []string{})
+	viper.SetDefault("enrichers.docker.refresh_interval", "60s")
+	viper.SetDefault("enrichers.docker.timeout", "5s")
+	viper.SetDefault("enrichers.cri.enabled", false)
+	viper.SetDefault("enrichers.cri.socket", "")
+	viper.SetDefault("enrichers.cri.refresh_interval", "30s")
+	viper.SetDefault("enrichers.cri.timeout", "5s")
+	viper.SetDefault("enrichers.kubernetes.enabled", false)
+	viper.SetDefault("enrichers.kubernetes.log_path", "/var/log")
+	viper.SetDefault("enrichers.kubernetes.refresh_interval", "30s")
+	viper.SetDefault("enrichers.kubelet.enabled", false)
+	viper.SetDefault("enrichers.kubelet.url", "https://localhost:10250")
+	viper.SetDefault("enrichers.kubelet.token_path", "/var/run/secrets/kubernetes.io/serviceaccount/token")
+	viper.SetDefault("enrichers.kubelet.ca_path", "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt")
+	viper.SetDefault("enrichers.kubelet.insecure_skip_verify", false)
+	viper.SetDefault("enrichers.kubelet.labels", []string{})
+	viper.SetDefault("enrichers.kubelet.annotations", []string{})
+	viper.SetDefault("enrichers.kubelet.refresh_interval", "30s")
+	viper.SetDefault("enrichers.kubelet.timeout", "5s")
+	viper.SetDefault("enrichers.podman.enabled", false)
+	viper.SetDefault("enrichers.podman.query_api", false)
+	viper.SetDefault("enrichers.podman.socket", "/run/podman/podman.sock")
+	viper.SetDefault("enrichers.podman.refresh_interval", "60s")
+	viper.SetDefault("enrichers.podman.timeout", "5s")
+	viper.SetDefault("enrichers.lxc.enabled", false)
+	viper.SetDefault("enrichers.nspawn.enabled", false)
+	viper.SetDefault("enrichers.slurm.enabled", false)
+	viper.SetDefault("enrichers.nomad.enabled", false)
 
 	// Logging defaults
 	viper.SetDefault("logging.level", "info")
@@ -147,6 +427,121 @@ func validate(config *Config) error {
 	if config.Cgroup.RefreshInterval <= 0 {
 		return fmt.Errorf("cgroup.refresh_interval must be positive")
 	}
+	switch config.Cgroup.LabelScheme {
+	case "", "name", "hierarchical":
+	default:
+		return fmt.Errorf("invalid cgroup.label_scheme: %s", config.Cgroup.LabelScheme)
+	}
+
+	// Validate probe configuration
+	for _, root := range config.Probe.AllowedRoots {
+		if !filepath.IsAbs(root) {
+			return fmt.Errorf("probe.allowed_roots: %q is not an absolute path", root)
+		}
+		if _, err := filepath.Match(root, ""); err != nil {
+			return fmt.Errorf("probe.allowed_roots: invalid pattern %q: %w", root, err)
+		}
+	}
+
+	// Validate remote write configuration
+	if remoteWrite := config.RemoteWrite; remoteWrite.URL != "" {
+		u, err := url.Parse(remoteWrite.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("remote_write.url: %q is not an http or https URL", remoteWrite.URL)
+		}
+		if remoteWrite.Interval <= 0 || remoteWrite.Timeout <= 0 {
+			return fmt.Errorf("remote_write.interval and timeout must be positive")
+		}
+		if remoteWrite.BasicAuth.Username != "" && remoteWrite.BearerToken != "" {
+			return fmt.Errorf("remote_write: at most one of basic_auth and bearer_token may be set")
+		}
+		queue := remoteWrite.Queue
+		if queue.Capacity <= 0 || queue.MaxSamplesPerSend <= 0 {
+			return fmt.Errorf("remote_write.queue.capacity and max_samples_per_send must be positive")
+		}
+		if queue.MaxRetries < 0 || queue.MinBackoff <= 0 || queue.MaxBackoff < queue.MinBackoff {
+			return fmt.Errorf("remote_write.queue: invalid retry settings")
+		}
+	}
+
+	// Validate OTLP configuration
+	if otlp := config.OTLP; otlp.Endpoint != "" {
+		switch otlp.Protocol {
+		case "", "http/protobuf":
+			u, err := url.Parse(otlp.Endpoint)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("otlp.endpoint: %q is not an http or https URL", otlp.Endpoint)
+			}
+		case "grpc":
+			if strings.Contains(otlp.Endpoint, "://") {
+				return fmt.Errorf("otlp.endpoint: %q must be host:port with the grpc protocol", otlp.Endpoint)
+			}
+		default:
+			return fmt.Errorf("invalid otlp.protocol: %s", otlp.Protocol)
+		}
+		if otlp.Interval <= 0 || otlp.Timeout <= 0 {
+			return fmt.Errorf("otlp.interval and timeout must be positive")
+		}
+	}
+	// Validate textfile output configuration
+	if output := config.Output; output.Textfile != "" {
+		if filepath.Ext(output.Textfile) != ".prom" {
+			return fmt.Errorf("output.textfile: %q must have the .prom extension read by node_exporter", output.Textfile)
+		}
+		if output.Interval <= 0 {
+			return fmt.Errorf("output.interval must be positive")
+		}
+	}
+
+	if config.Web.DisableMetricsEndpoint && config.RemoteWrite.URL == "" && config.OTLP.Endpoint == "" {
+		return fmt.Errorf("web.disable_metrics_endpoint requires remote_write.url or otlp.endpoint")
+	}
+
+	// Validate enricher configuration
+	if docker := config.Enrichers.Docker; docker.Enabled && docker.QueryAPI {
+		if docker.Socket == "" {
+			return fmt.Errorf("enrichers.docker.socket cannot be empty when query_api is enabled")
+		}
+		if docker.RefreshInterval <= 0 || docker.Timeout <= 0 {
+			return fmt.Errorf("enrichers.docker.refresh_interval and timeout must be positive")
+		}
+	}
+	if cri := config.Enrichers.CRI; cri.Enabled {
+		if cri.RefreshInterval <= 0 || cri.Timeout <= 0 {
+			return fmt.Errorf("enrichers.cri.refresh_interval and timeout must be positive")
+		}
+	}
+	if kubernetes := config.Enrichers.Kubernetes; kubernetes.Enabled {
+		if kubernetes.LogPath == "" {
+			return fmt.Errorf("enrichers.kubernetes.log_path cannot be empty")
+		}
+		if kubernetes.RefreshInterval <= 0 {
+			return fmt.Errorf("enrichers.kubernetes.refresh_interval must be positive")
+		}
+	}
+	if kubelet := config.Enrichers.Kubelet; kubelet.Enabled {
+		if kubelet.URL == "" {
+			return fmt.Errorf("enrichers.kubelet.url cannot be empty")
+		}
+		if kubelet.RefreshInterval <= 0 || kubelet.Timeout <= 0 {
+			return fmt.Errorf("enrichers.kubelet.refresh_interval and timeout must be positive")
+		}
+	}
+	if podman := config.Enrichers.Podman; podman.Enabled && podman.QueryAPI {
+		if podman.Socket == "" {
+			return fmt.Errorf("enrichers.podman.socket cannot be empty when query_api is enabled")
+		}
+		if podman.RefreshInterval <= 0 || podman.Timeout <= 0 {
+			return fmt.Errorf("enrichers.podman.refresh_interval and timeout must be positive")
+		}
+	}
+
+	if _, err := relabel.Compile(config.RelabelConfigs); err != nil {
+		return fmt.Errorf("invalid relabel_configs: %w", err)
+	}
+	if _, err := relabel.Compile(config.MetricRelabelConfigs); err != nil {
+		return fmt.Errorf("invalid metric_relabel_configs: %w", err)
+	}
 
 	// Validate logging configuration
 	validLogLevels := map[string]bool{
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// secretPlaceholder replaces configured secrets in redacted configurations
+const secretPlaceholder = "<secret>"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Redacted returns the configuration as nested maps keyed like the
+// configuration file, for display. Fields tagged `secret:"true"` are
+// replaced by a placeholder when set, as are passwords in URLs.
+func (c *Config) Redacted() map[string]interface{} {
+	return redact(reflect.ValueOf(*c), false).(map[string]interface{})
+}
+
+func redact(v reflect.Value, secret bool) interface{} {
+	switch v.Kind() {
+	case reflect.Struct:
+		fields := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			key, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+			if !field.IsExported() || key == "" || key == "-" {
+				continue
+			}
+			fields[key] = redact(v.Field(i), field.Tag.Get("secret") == "true")
+		}
+		return fields
+	case reflect.Slice:
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = redact(v.Index(i), secret)
+		}
+		return items
+	case reflect.Map:
+		entries := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			entries[iter.Key().String()] = redact(iter.Value(), secret)
+		}
+		return entries
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return redact(v.Elem(), secret)
+	}
+
+	switch {
+	case secret && !v.IsZero():
+		return secretPlaceholder
+	case v.Type() == durationType:
+		return v.Interface().(time.Duration).String()
+	case v.Kind() == reflect.String:
+		return redactURL(v.String())
+	}
+	return v.Interface()
+}
+
+// redactURL hides the password of a URL with user info, e.g. the URL of an
+// authenticating proxy. Other strings are returned as is.
+func redactURL(s string) string {
+	if !strings.Contains(s, "@") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,279 @@
+package enricher
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+)
+
+// criScope matches the scope of a containerd or CRI-O container (or pod
+// sandbox) under the systemd cgroup driver
+var criScope = regexp.MustCompile(`^(cri-containerd|crio)-([0-9a-f]{64})\.scope$`)
+
+// criRuntimes maps CRI scope prefixes to runtime names
+var criRuntimes = map[string]string{
+	"cri-containerd": "containerd",
+	"crio":           "cri-o",
+}
+
+// defaultCRISockets are probed in order when no CRI socket is configured
+var defaultCRISockets = []string{
+	"/run/containerd/containerd.sock",
+	"/var/run/crio/crio.sock",
+}
+
+// Kubernetes labels set by the kubelet on CRI containers and sandboxes
+const (
+	kubernetesContainerNameLabel = "io.kubernetes.container.name"
+	kubernetesPodNameLabel       = "io.kubernetes.pod.name"
+	kubernetesPodNamespaceLabel  = "io.kubernetes.pod.namespace"
+	criImageNameAnnotation       = "io.kubernetes.cri.image-name"
+)
+
+// criContainer is the cached metadata of a CRI container or pod sandbox
+type criContainer struct {
+	Name      string
+	Pod       string
+	Namespace string
+	Image     string
+}
+
+// CRIEnricher labels containerd and CRI-O container cgroups with container,
+// pod and image metadata from the CRI runtime service. When the runtime
+// socket is missing, only runtime and container_id are derived from the
+// cgroup path.
+type CRIEnricher struct {
+	config config.CRIEnricherConfig
+	logger *logrus.Logger
+
+	connMutex sync.Mutex
+	conn      *grpc.ClientConn
+	socket    string
+
+	mutex      sync.RWMutex
+	containers map[string]*criContainer
+}
+
+// NewCRIEnricher creates a CRI enricher
+func NewCRIEnricher(cfg config.CRIEnricherConfig, logger *logrus.Logger) *CRIEnricher {
+	return &CRIEnricher{
+		config:     cfg,
+		logger:     logger,
+		containers: make(map[string]*criContainer),
+	}
+}
+
+// Name returns the enricher name
+func (e *CRIEnricher) Name() string {
+	return "cri"
+}
+
+// Enrich implements cgroup.Enricher
+func (e *CRIEnricher) Enrich(cg *cgroup.CgroupInfo) {
+	runtime, id := criContainerID(cg.Path)
+	if id == "" {
+		return
+	}
+
+	cg.Labels["runtime"] = runtime
+	cg.Labels["container_id"] = id
+
+	e.mutex.RLock()
+	container, ok := e.containers[id]
+	e.mutex.RUnlock()
+	if !ok {
+		return
+	}
+
+	setIfNotEmpty(cg.Labels, "container_name", container.Name)
+	setIfNotEmpty(cg.Labels, "pod_name", container.Pod)
+	setIfNotEmpty(cg.Labels, "namespace", container.Namespace)
+	setIfNotEmpty(cg.Labels, "image", container.Image)
+}
+
+// criContainerID extracts the runtime and ID of the innermost CRI container
+// in a cgroup path
+func criContainerID(cgroupPath string) (runtime, id string) {
+	for _, component := range strings.Split(strings.Trim(cgroupPath, "/"), "/") {
+		if match := criScope.FindStringSubmatch(component); match != nil {
+			runtime, id = criRuntimes[match[1]], match[2]
+		}
+	}
+	return runtime, id
+}
+
+// Start refreshes the container cache periodically until the context is cancelled
+func (e *CRIEnricher) Start(ctx context.Context) error {
+	ticker := time.NewTicker(e.config.RefreshInterval)
+	defer ticker.Stop()
+	defer e.close()
+
+	for {
+		if err := e.Refresh(ctx); err != nil && ctx.Err() == nil {
+			e.logger.WithError(err).Warn("Failed to list CRI containers")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh replaces the container cache with the containers and pod
+// sandboxes known to the runtime
+func (e *CRIEnricher) Refresh(ctx context.Context) error {
+	conn, err := e.connect()
+	if err != nil {
+		return err
+	}
+	if conn == nil {
+		// No runtime socket on this node, or it went away with the runtime:
+		// drop the cached containers and fall back to path-derived labels
+		e.mutex.Lock()
+		e.containers = make(map[string]*criContainer)
+		e.mutex.Unlock()
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.config.Timeout)
+	defer cancel()
+
+	client := runtimeapi.NewRuntimeServiceClient(conn)
+
+	sandboxes, err := client.ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{})
+	if err != nil {
+		return err
+	}
+	containers, err := client.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+	if err != nil {
+		return err
+	}
+
+	cache := make(map[string]*criContainer, len(sandboxes.Items)+len(containers.Containers))
+	pods := make(map[string]*criContainer, len(sandboxes.Items))
+	for _, sandbox := range sandboxes.Items {
+		pod := &criContainer{}
+		if metadata := sandbox.GetMetadata(); metadata != nil {
+			pod.Pod = metadata.Name
+			pod.Namespace = metadata.Namespace
+		}
+		pods[sandbox.Id] = pod
+		cache[sandbox.Id] = pod
+	}
+
+	for _, c := range containers.Containers {
+		container := &criContainer{
+			Name:      c.Labels[kubernetesContainerNameLabel],
+			Pod:       c.Labels[kubernetesPodNameLabel],
+			Namespace: c.Labels[kubernetesPodNamespaceLabel],
+			Image:     criImageName(c),
+		}
+		if metadata := c.GetMetadata(); metadata != nil && metadata.Name != "" {
+			container.Name = metadata.Name
+		}
+		if pod, ok := pods[c.PodSandboxId]; ok {
+			container.Pod = pod.Pod
+			container.Namespace = pod.Namespace
+		}
+		cache[c.Id] = container
+	}
+
+	e.logger.WithField("containers", len(cache)).Debug("Refreshed CRI containers")
+
+	e.mutex.Lock()
+	e.containers = cache
+	e.mutex.Unlock()
+	return nil
+}
+
+// criImageName returns the most readable image reference of a container
+func criImageName(c *runtimeapi.Container) string {
+	if name := c.Annotations[criImageNameAnnotation]; name != "" {
+		return name
+	}
+	if image := c.GetImage(); image != nil && image.Image != "" {
+		return image.Image
+	}
+	return c.ImageRef
+}
+
+// connect returns a connection to the runtime socket, or nil if no socket
+// exists, closing the connection to a socket that disappeared
+func (e *CRIEnricher) connect() (*grpc.ClientConn, error) {
+	e.connMutex.Lock()
+	defer e.connMutex.Unlock()
+
+	socket := e.findSocket()
+	if socket == "" {
+		if e.conn != nil {
+			e.conn.Close()
+			e.conn = nil
+		}
+		return nil, nil
+	}
+
+	if e.conn != nil && e.socket == socket {
+		return e.conn, nil
+	}
+	if e.conn != nil {
+		e.conn.Close()
+	}
+
+	conn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+
+	e.logger.WithField("socket", socket).Info("Connected to CRI runtime")
+	e.conn = conn
+	e.socket = socket
+	return conn, nil
+}
+
+// findSocket returns the configured or first existing default runtime socket
+func (e *CRIEnricher) findSocket() string {
+	candidates := defaultCRISockets
+	if e.config.Socket != "" {
+		candidates = []string{e.config.Socket}
+	}
+
+	for _, socket := range candidates {
+		if _, err := os.Stat(socket); err == nil {
+			return socket
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			e.logger.WithError(err).WithField("socket", socket).Debug("Failed to stat CRI socket")
+		}
+	}
+	return ""
+}
+
+func (e *CRIEnricher) close() {
+	e.connMutex.Lock()
+	defer e.connMutex.Unlock()
+
+	if e.conn != nil {
+		e.conn.Close()
+		e.conn = nil
+	}
+}
+
+func setIfNotEmpty(labels map[string]string, name, value string) {
+	if value != "" {
+		labels[name] = value
+	}
+}
@@ -0,0 +1,147 @@
+package enricher
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+)
+
+// fakeCRI implements the listing calls of the CRI runtime service
+type fakeCRI struct {
+	runtimeapi.UnimplementedRuntimeServiceServer
+}
+
+func (f *fakeCRI) ListPodSandbox(ctx context.Context, req *runtimeapi.ListPodSandboxRequest) (*runtimeapi.ListPodSandboxResponse, error) {
+	return &runtimeapi.ListPodSandboxResponse{Items: []*runtimeapi.PodSandbox{{
+		Id:       otherContainerID,
+		Metadata: &runtimeapi.PodSandboxMetadata{Name: "web-7d9f", Namespace: "shop"},
+	}}}, nil
+}
+
+func (f *fakeCRI) ListContainers(ctx context.Context, req *runtimeapi.ListContainersRequest) (*runtimeapi.ListContainersResponse, error) {
+	return &runtimeapi.ListContainersResponse{Containers: []*runtimeapi.Container{{
+		Id:           testContainerID,
+		PodSandboxId: otherContainerID,
+		Metadata:     &runtimeapi.ContainerMetadata{Name: "nginx"},
+		Image:        &runtimeapi.ImageSpec{Image: "sha256:abc"},
+		Annotations:  map[string]string{criImageNameAnnotation: "docker.io/library/nginx:1.25"},
+	}}}, nil
+}
+
+// newFakeCRI serves the fake runtime service on a unix socket
+func newFakeCRI(t *testing.T) string {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "containerd.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on %s: %v", socket, err)
+	}
+
+	server := grpc.NewServer()
+	runtimeapi.RegisterRuntimeServiceServer(server, &fakeCRI{})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	return socket
+}
+
+func TestCRIContainerID(t *testing.T) {
+	tests := map[string]string{
+		"/kubepods.slice/kubepods-pod1.slice/cri-containerd-" + testContainerID + ".scope": testContainerID,
+		"/kubepods.slice/kubepods-pod1.slice/crio-" + testContainerID + ".scope":           testContainerID,
+		"/kubepods.slice/kubepods-pod1.slice/crio-conmon-" + testContainerID + ".scope":    "",
+		"/system.slice/containerd.service":                                                 "",
+	}
+
+	for path, want := range tests {
+		if _, got := criContainerID(path); got != want {
+			t.Errorf("criContainerID(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestCRIEnricher_MissingSocket(t *testing.T) {
+	e := NewCRIEnricher(config.CRIEnricherConfig{
+		Enabled:         true,
+		Socket:          filepath.Join(t.TempDir(), "missing.sock"),
+		RefreshInterval: time.Hour,
+		Timeout:         time.Second,
+	}, logrus.New())
+
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Errorf("Refresh without a socket should fall back silently, got %v", err)
+	}
+
+	labels := enrich(e, "/kubepods.slice/crio-"+testContainerID+".scope")
+	if len(labels) != 2 || labels["container_id"] != testContainerID || labels["runtime"] != "cri-o" {
+		t.Errorf("Expected only runtime and container_id, got %v", labels)
+	}
+}
+
+func TestCRIEnricher_Runtime(t *testing.T) {
+	socket := newFakeCRI(t)
+
+	e := NewCRIEnricher(config.CRIEnricherConfig{
+		Enabled:         true,
+		Socket:          socket,
+		RefreshInterval: time.Hour,
+		Timeout:         5 * time.Second,
+	}, logrus.New())
+	defer e.close()
+
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+
+	labels := enrich(e, "/kubepods.slice/kubepods-pod1.slice/cri-containerd-"+testContainerID+".scope")
+	want := map[string]string{
+		"runtime":        "containerd",
+		"container_id":   testContainerID,
+		"container_name": "nginx",
+		"pod_name":       "web-7d9f",
+		"namespace":      "shop",
+		"image":          "docker.io/library/nginx:1.25",
+	}
+	if len(labels) != len(want) {
+		t.Errorf("Expected labels %v, got %v", want, labels)
+	}
+	for name, value := range want {
+		if labels[name] != value {
+			t.Errorf("Expected %s=%q, got %q", name, value, labels[name])
+		}
+	}
+
+	// The pod sandbox scope carries the pod labels only
+	labels = enrich(e, "/kubepods.slice/kubepods-pod1.slice/cri-containerd-"+otherContainerID+".scope")
+	if labels["pod_name"] != "web-7d9f" || labels["namespace"] != "shop" {
+		t.Errorf("Expected pod labels on the sandbox, got %v", labels)
+	}
+	if _, ok := labels["container_name"]; ok {
+		t.Errorf("Expected no container_name on the sandbox, got %v", labels)
+	}
+
+	// Labels of the runtime are dropped when its socket disappears
+	if err := os.Remove(socket); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	labels = enrich(e, "/kubepods.slice/kubepods-pod1.slice/cri-containerd-"+testContainerID+".scope")
+	if len(labels) != 2 || labels["container_id"] != testContainerID {
+		t.Errorf("Expected only path-derived labels without the socket, got %v", labels)
+	}
+	if e.conn != nil {
+		t.Error("Expected the connection to the removed socket to be closed")
+	}
+}
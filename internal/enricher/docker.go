@@ -0,0 +1,283 @@
+package enricher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+)
+
+var (
+	// dockerScope matches the scope of a container under the systemd driver
+	dockerScope = regexp.MustCompile(`^docker-([0-9a-f]{64})\.scope$`)
+	// containerID matches a full container ID as used by the cgroupfs driver
+	containerID = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// dockerContainer is the cached metadata of a Docker container
+type dockerContainer struct {
+	Name   string
+	Image  string
+	Labels map[string]string
+}
+
+// DockerEnricher labels Docker container cgroups with their runtime and
+// container ID and, when the Engine API is enabled, with the container name,
+// image and an allow-list of container labels. Metadata is cached, refreshed
+// periodically and updated on container events.
+type DockerEnricher struct {
+	config config.DockerEnricherConfig
+	client *http.Client
+	logger *logrus.Logger
+
+	mutex      sync.RWMutex
+	containers map[string]*dockerContainer
+}
+
+// NewDockerEnricher creates a Docker enricher
+func NewDockerEnricher(cfg config.DockerEnricherConfig, logger *logrus.Logger) *DockerEnricher {
+	socket := cfg.Socket
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+
+	return &DockerEnricher{
+		config:     cfg,
+		client:     &http.Client{Transport: transport},
+		logger:     logger,
+		containers: make(map[string]*dockerContainer),
+	}
+}
+
+// Name returns the enricher name
+func (e *DockerEnricher) Name() string {
+	return "docker"
+}
+
+// Enrich implements cgroup.Enricher
+func (e *DockerEnricher) Enrich(cg *cgroup.CgroupInfo) {
+	id := dockerContainerID(cg.Path)
+	if id == "" {
+		return
+	}
+
+	cg.Labels["runtime"] = "docker"
+	cg.Labels["container_id"] = id
+
+	container, ok := e.lookup(id)
+	if !ok {
+		return
+	}
+
+	cg.Labels["container_name"] = container.Name
+	cg.Labels["image"] = container.Image
+	for _, label := range e.config.Labels {
+		if value, ok := container.Labels[label]; ok {
+			cg.Labels[ContainerLabelName(label)] = value
+		}
+	}
+}
+
+// lookup returns the cached metadata of a container
+func (e *DockerEnricher) lookup(id string) (*dockerContainer, bool) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	container, ok := e.containers[id]
+	return container, ok
+}
+
+// dockerContainerID extracts the ID of the innermost Docker container in a
+// cgroup path, for both the systemd ("docker-<id>.scope") and the cgroupfs
+// ("docker/<id>") cgroup drivers
+func dockerContainerID(cgroupPath string) string {
+	var id, previous string
+	for _, component := range strings.Split(strings.Trim(cgroupPath, "/"), "/") {
+		if match := dockerScope.FindStringSubmatch(component); match != nil {
+			id = match[1]
+		} else if previous == "docker" && containerID.MatchString(component) {
+			id = component
+		}
+		previous = component
+	}
+	return id
+}
+
+// ContainerLabelName converts a container label key such as
+// "com.example.team" into the Prometheus label "container_label_com_example_team"
+func ContainerLabelName(key string) string {
+	return cgroup.LabelName("container_label_", key)
+}
+
+// Start keeps the container cache up to date until the context is cancelled,
+// combining periodic resynchronization with the container event stream. It
+// is a no-op unless the Engine API is enabled.
+func (e *DockerEnricher) Start(ctx context.Context) error {
+	if !e.config.QueryAPI {
+		return nil
+	}
+
+	go e.refreshLoop(ctx)
+
+	for {
+		if err := e.watchEvents(ctx); err != nil && ctx.Err() == nil {
+			e.logger.WithError(err).Debug("Docker event stream closed")
+		}
+
+		// Avoid hammering a daemon that is down
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(e.config.RefreshInterval):
+		}
+	}
+}
+
+// refreshLoop periodically resynchronizes the container cache
+func (e *DockerEnricher) refreshLoop(ctx context.Context) {
+	ticker := time.NewTicker(e.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.Refresh(ctx); err != nil && ctx.Err() == nil {
+			e.logger.WithError(err).Warn("Failed to list Docker containers")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh replaces the container cache with the running containers
+func (e *DockerEnricher) Refresh(ctx context.Context) error {
+	var list []struct {
+		ID     string            `json:"Id"`
+		Names  []string          `json:"Names"`
+		Image  string            `json:"Image"`
+		Labels map[string]string `json:"Labels"`
+	}
+	if err := e.get(ctx, "/containers/json", &list); err != nil {
+		return err
+	}
+
+	containers := make(map[string]*dockerContainer, len(list))
+	for _, c := range list {
+		var name string
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		containers[c.ID] = &dockerContainer{Name: name, Image: c.Image, Labels: c.Labels}
+	}
+
+	e.logger.WithField("containers", len(containers)).Debug("Refreshed Docker containers")
+
+	e.mutex.Lock()
+	e.containers = containers
+	e.mutex.Unlock()
+	return nil
+}
+
+// watchEvents applies container events to the cache until the stream ends
+func (e *DockerEnricher) watchEvents(ctx context.Context) error {
+	filters := url.QueryEscape(`{"type":["container"]}`)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker/events?filters="+filters, http.NoBody)
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status from Docker events: %s", resp.Status)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Action string `json:"Action"`
+			Actor  struct {
+				ID string `json:"ID"`
+			} `json:"Actor"`
+		}
+		if err := decoder.Decode(&event); err != nil {
+			return err
+		}
+
+		switch event.Action {
+		case "start", "rename", "update":
+			if err := e.inspect(ctx, event.Actor.ID); err != nil {
+				e.logger.WithError(err).WithField("container_id", event.Actor.ID).Debug("Failed to inspect Docker container")
+			}
+		case "destroy":
+			e.mutex.Lock()
+			delete(e.containers, event.Actor.ID)
+			e.mutex.Unlock()
+		}
+	}
+}
+
+// inspect updates the cache entry of a single container
+func (e *DockerEnricher) inspect(ctx context.Context, id string) error {
+	var details struct {
+		Name   string `json:"Name"`
+		Config struct {
+			Image  string            `json:"Image"`
+			Labels map[string]string `json:"Labels"`
+		} `json:"Config"`
+	}
+	if err := e.get(ctx, "/containers/"+url.PathEscape(id)+"/json", &details); err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	e.containers[id] = &dockerContainer{
+		Name:   strings.TrimPrefix(details.Name, "/"),
+		Image:  details.Config.Image,
+		Labels: details.Config.Labels,
+	}
+	e.mutex.Unlock()
+	return nil
+}
+
+// get performs an Engine API request and decodes the JSON response
+func (e *DockerEnricher) get(ctx context.Context, path string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, e.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker"+path, http.NoBody)
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status from Docker %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
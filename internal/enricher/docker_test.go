@@ -0,0 +1,164 @@
+package enricher
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+)
+
+const (
+	testContainerID  = "3f4b2a1c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a"
+	otherContainerID = "0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f9"
+)
+
+// newFakeDocker serves a minimal Docker Engine API on a unix socket
+func newFakeDocker(t *testing.T, events chan string) string {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on %s: %v", socket, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]interface{}{{
+			"Id":     testContainerID,
+			"Names":  []string{"/web"},
+			"Image":  "nginx:1.25",
+			"Labels": map[string]string{"com.example.team": "frontend", "ignored": "x"},
+		}})
+	})
+	mux.HandleFunc("/containers/"+otherContainerID+"/json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Name":   "/worker",
+			"Config": map[string]interface{}{"Image": "busybox", "Labels": map[string]string{}},
+		})
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Query().Get("filters"), "container") {
+			t.Errorf("Expected container event filter, got %q", r.URL.RawQuery)
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case event := <-events:
+				w.Write([]byte(event + "\n"))
+				w.(http.Flusher).Flush()
+			}
+		}
+	})
+
+	server := httptest.NewUnstartedServer(mux)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return socket
+}
+
+func TestDockerContainerID(t *testing.T) {
+	tests := map[string]string{
+		"/system.slice/docker-" + testContainerID + ".scope": testContainerID,
+		"/docker/" + testContainerID:                         testContainerID,
+		"/docker/" + testContainerID + "/init.scope":         testContainerID,
+		"/system.slice/docker.service":                       "",
+		"/docker/not-an-id":                                  "",
+	}
+
+	for path, want := range tests {
+		if got := dockerContainerID(path); got != want {
+			t.Errorf("dockerContainerID(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestDockerEnricher_PathOnly(t *testing.T) {
+	e := NewDockerEnricher(config.DockerEnricherConfig{Enabled: true}, logrus.New())
+
+	labels := enrich(e, "/system.slice/docker-"+testContainerID+".scope")
+	if len(labels) != 2 || labels["container_id"] != testContainerID || labels["runtime"] != "docker" {
+		t.Errorf("Expected only runtime and container_id, got %v", labels)
+	}
+	if err := e.Start(context.Background()); err != nil {
+		t.Errorf("Start without the API should be a no-op, got %v", err)
+	}
+}
+
+func TestDockerEnricher_API(t *testing.T) {
+	events := make(chan string, 1)
+	socket := newFakeDocker(t, events)
+
+	e := NewDockerEnricher(config.DockerEnricherConfig{
+		Enabled:         true,
+		QueryAPI:        true,
+		Socket:          socket,
+		Labels:          []string{"com.example.team"},
+		RefreshInterval: time.Hour,
+		Timeout:         time.Second,
+	}, logrus.New())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Start(ctx)
+
+	waitFor(t, func() bool {
+		return enrich(e, "/docker/"+testContainerID)["container_name"] == "web"
+	})
+
+	labels := enrich(e, "/docker/"+testContainerID)
+	want := map[string]string{
+		"runtime":                          "docker",
+		"container_id":                     testContainerID,
+		"container_name":                   "web",
+		"image":                            "nginx:1.25",
+		"container_label_com_example_team": "frontend",
+	}
+	if len(labels) != len(want) {
+		t.Errorf("Expected labels %v, got %v", want, labels)
+	}
+	for name, value := range want {
+		if labels[name] != value {
+			t.Errorf("Expected %s=%q, got %q", name, value, labels[name])
+		}
+	}
+
+	// A started container is picked up from the event stream
+	events <- `{"Type":"container","Action":"start","Actor":{"ID":"` + otherContainerID + `"}}`
+	waitFor(t, func() bool {
+		return enrich(e, "/docker/"+otherContainerID)["container_name"] == "worker"
+	})
+
+	// A destroyed container is dropped from the cache
+	events <- `{"Type":"container","Action":"destroy","Actor":{"ID":"` + otherContainerID + `"}}`
+	waitFor(t, func() bool {
+		_, ok := enrich(e, "/docker/"+otherContainerID)["container_name"]
+		return !ok
+	})
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,57 @@
+// Package enricher implements cgroup enrichers, which attach workload
+// metadata such as systemd units or container names to discovered cgroups.
+package enricher
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/fsys"
+)
+
+// New creates the enrichers enabled in the configuration, in the order they
+// are applied to each cgroup
+func New(cfg *config.Config, logger *logrus.Logger) ([]cgroup.Enricher, error) {
+	var enrichers []cgroup.Enricher
+
+	if cfg.Enrichers.Systemd.Enabled {
+		enrichers = append(enrichers, NewSystemdEnricher(cfg.Enrichers.Systemd.PasswdPath, logger))
+	}
+	if cfg.Enrichers.Docker.Enabled {
+		enrichers = append(enrichers, NewDockerEnricher(cfg.Enrichers.Docker, logger))
+	}
+	if cfg.Enrichers.CRI.Enabled {
+		enrichers = append(enrichers, NewCRIEnricher(cfg.Enrichers.CRI, logger))
+	}
+	if k := cfg.Enrichers.Kubernetes; k.Enabled {
+		enrichers = append(enrichers, NewKubernetesEnricher(fsys.NewOS(k.LogPath), k.RefreshInterval, logger))
+	}
+	if cfg.Enrichers.Kubelet.Enabled {
+		kubelet, err := NewKubeletEnricher(cfg.Enrichers.Kubelet, logger)
+		if err != nil {
+			return nil, err
+		}
+		enrichers = append(enrichers, kubelet)
+	}
+	if cfg.Enrichers.Podman.Enabled {
+		enrichers = append(enrichers, NewPodmanEnricher(cfg.Enrichers.Podman, logger))
+	}
+	if cfg.Enrichers.LXC.Enabled {
+		enrichers = append(enrichers, NewLXCEnricher())
+	}
+	if cfg.Enrichers.Nspawn.Enabled {
+		enrichers = append(enrichers, NewNspawnEnricher())
+	}
+	if cfg.Enrichers.Slurm.Enabled {
+		enrichers = append(enrichers, NewSlurmEnricher())
+	}
+	if cfg.Enrichers.Nomad.Enabled {
+		enrichers = append(enrichers, NewNomadEnricher())
+	}
+
+	for _, e := range enrichers {
+		logger.WithField("enricher", e.Name()).Info("Enabled cgroup enricher")
+	}
+	return enrichers, nil
+}
@@ -0,0 +1,192 @@
+package enricher
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+)
+
+// kubeletPod is the cached metadata of a pod known to the kubelet
+type kubeletPod struct {
+	Namespace   string
+	Name        string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// KubeletEnricher labels the cgroups of Kubernetes pods with their namespace,
+// name and an allow-list of pod labels and annotations from the local
+// kubelet's /pods endpoint. Pods are matched by the UID in the kubepods path.
+type KubeletEnricher struct {
+	config config.KubeletEnricherConfig
+	client *http.Client
+	logger *logrus.Logger
+
+	mutex sync.RWMutex
+	pods  map[string]*kubeletPod
+}
+
+// NewKubeletEnricher creates a kubelet enricher
+func NewKubeletEnricher(cfg config.KubeletEnricherConfig, logger *logrus.Logger) (*KubeletEnricher, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAPath != "" {
+		ca, err := os.ReadFile(cfg.CAPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kubelet CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in kubelet CA bundle %s", cfg.CAPath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &KubeletEnricher{
+		config: cfg,
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+			Timeout:   cfg.Timeout,
+		},
+		logger: logger,
+		pods:   make(map[string]*kubeletPod),
+	}, nil
+}
+
+// Name returns the enricher name
+func (e *KubeletEnricher) Name() string {
+	return "kubelet"
+}
+
+// Enrich implements cgroup.Enricher. It adds namespace, pod_name and the
+// allow-listed pod_label_<key> and pod_annotation_<key> labels.
+func (e *KubeletEnricher) Enrich(cg *cgroup.CgroupInfo) {
+	_, podUID, _, ok := parseKubepodsPath(cg.Path)
+	if !ok || podUID == "" {
+		return
+	}
+
+	e.mutex.RLock()
+	pod, ok := e.pods[podUID]
+	e.mutex.RUnlock()
+	if !ok {
+		return
+	}
+
+	cg.Labels["namespace"] = pod.Namespace
+	cg.Labels["pod_name"] = pod.Name
+	for _, key := range e.config.Labels {
+		if value, ok := pod.Labels[key]; ok {
+			cg.Labels[PodLabelName(key)] = value
+		}
+	}
+	for _, key := range e.config.Annotations {
+		if value, ok := pod.Annotations[key]; ok {
+			cg.Labels[PodAnnotationName(key)] = value
+		}
+	}
+}
+
+// PodLabelName converts a pod label key such as "app.kubernetes.io/name"
+// into the Prometheus label "pod_label_app_kubernetes_io_name"
+func PodLabelName(key string) string {
+	return cgroup.LabelName("pod_label_", key)
+}
+
+// PodAnnotationName converts a pod annotation key into a Prometheus label
+// name like PodLabelName, with a "pod_annotation_" prefix
+func PodAnnotationName(key string) string {
+	return cgroup.LabelName("pod_annotation_", key)
+}
+
+// Start refreshes the pod cache periodically until the context is cancelled
+func (e *KubeletEnricher) Start(ctx context.Context) error {
+	ticker := time.NewTicker(e.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.Refresh(ctx); err != nil && ctx.Err() == nil {
+			e.logger.WithError(err).Warn("Failed to list kubelet pods")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh replaces the pod cache with the pods running on the node
+func (e *KubeletEnricher) Refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(e.config.URL, "/")+"/pods", http.NoBody)
+	if err != nil {
+		return err
+	}
+
+	// Projected service account tokens are rotated, so the token is re-read
+	// for every request
+	if e.config.TokenPath != "" {
+		token, err := os.ReadFile(e.config.TokenPath)
+		if err != nil {
+			return fmt.Errorf("failed to read kubelet token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status from kubelet /pods: %s", resp.Status)
+	}
+
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name        string            `json:"name"`
+				Namespace   string            `json:"namespace"`
+				UID         string            `json:"uid"`
+				Labels      map[string]string `json:"labels"`
+				Annotations map[string]string `json:"annotations"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return fmt.Errorf("failed to decode kubelet pods: %w", err)
+	}
+
+	pods := make(map[string]*kubeletPod, len(list.Items))
+	for _, item := range list.Items {
+		pods[item.Metadata.UID] = &kubeletPod{
+			Namespace:   item.Metadata.Namespace,
+			Name:        item.Metadata.Name,
+			Labels:      item.Metadata.Labels,
+			Annotations: item.Metadata.Annotations,
+		}
+	}
+
+	e.logger.WithField("pods", len(pods)).Debug("Refreshed kubelet pods")
+
+	e.mutex.Lock()
+	e.pods = pods
+	e.mutex.Unlock()
+	return nil
+}
@@ -0,0 +1,125 @@
+package enricher
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+)
+
+// newFakeKubelet serves a kubelet /pods endpoint over TLS and returns its
+// URL and the path of a CA bundle trusting it
+func newFakeKubelet(t *testing.T, token string) (string, string) {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pods" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"kind": "PodList",
+			"items": []map[string]interface{}{{
+				"metadata": map[string]interface{}{
+					"name":        "web-7d9f",
+					"namespace":   "shop",
+					"uid":         testPodUID,
+					"labels":      map[string]string{"app": "web", "team": "checkout", "pod-template-hash": "7d9f"},
+					"annotations": map[string]string{"example.com/cost-center": "cc-42"},
+				},
+			}},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	caPath := filepath.Join(t.TempDir(), "ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caPath, ca, 0o600); err != nil {
+		t.Fatalf("Failed to write CA bundle: %v", err)
+	}
+
+	return server.URL, caPath
+}
+
+func TestKubeletEnricher(t *testing.T) {
+	url, caPath := newFakeKubelet(t, "secret")
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("secret\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write token: %v", err)
+	}
+
+	e, err := NewKubeletEnricher(config.KubeletEnricherConfig{
+		Enabled:         true,
+		URL:             url,
+		TokenPath:       tokenPath,
+		CAPath:          caPath,
+		Labels:          []string{"app", "team"},
+		Annotations:     []string{"example.com/cost-center"},
+		RefreshInterval: time.Hour,
+		Timeout:         5 * time.Second,
+	}, logrus.New())
+	if err != nil {
+		t.Fatalf("NewKubeletEnricher failed: %v", err)
+	}
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+
+	// Every cgroup of the pod carries the pod metadata
+	for _, path := range []string{
+		"/kubepods/burstable/pod" + testPodUID,
+		"/kubepods/burstable/pod" + testPodUID + "/" + testContainerID,
+	} {
+		labels := enrich(e, path)
+		want := map[string]string{
+			"namespace":                              "shop",
+			"pod_name":                               "web-7d9f",
+			"pod_label_app":                          "web",
+			"pod_label_team":                         "checkout",
+			"pod_annotation_example_com_cost_center": "cc-42",
+		}
+		if len(labels) != len(want) {
+			t.Errorf("Expected labels %v for %s, got %v", want, path, labels)
+		}
+		for name, value := range want {
+			if labels[name] != value {
+				t.Errorf("Expected %s=%q for %s, got %q", name, value, path, labels[name])
+			}
+		}
+	}
+
+	if labels := enrich(e, "/system.slice/kubelet.service"); len(labels) != 0 {
+		t.Errorf("Expected no labels outside of kubepods, got %v", labels)
+	}
+}
+
+func TestKubeletEnricher_Unauthorized(t *testing.T) {
+	url, caPath := newFakeKubelet(t, "secret")
+
+	e, err := NewKubeletEnricher(config.KubeletEnricherConfig{
+		Enabled: true,
+		URL:     url,
+		CAPath:  caPath,
+		Timeout: 5 * time.Second,
+	}, logrus.New())
+	if err != nil {
+		t.Fatalf("NewKubeletEnricher failed: %v", err)
+	}
+	if err := e.Refresh(context.Background()); err == nil {
+		t.Error("Expected an error without a token")
+	}
+}
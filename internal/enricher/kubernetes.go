@@ -0,0 +1,223 @@
+package enricher
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/fsys"
+)
+
+var (
+	// kubepodsSlice matches the QoS and pod slices of the systemd cgroup
+	// driver, e.g. "kubepods-burstable-pod<uid>.slice" with dashes in the
+	// UID escaped as underscores
+	kubepodsSlice = regexp.MustCompile(`^kubepods(?:-(besteffort|burstable))?(?:-pod([0-9a-f_]+))?\.slice$`)
+	// kubepodsPod matches the pod directory of the cgroupfs driver
+	kubepodsPod = regexp.MustCompile(`^pod([0-9a-f-]+)$`)
+	// kubeContainerScope matches a container scope of any CRI runtime
+	kubeContainerScope = regexp.MustCompile(`^(?:cri-containerd|crio|docker)-([0-9a-f]{64})\.scope$`)
+	// containerLogName matches the kubelet's /var/log/containers symlinks,
+	// "<pod>_<namespace>_<container>-<id>.log"
+	containerLogName = regexp.MustCompile(`^([^_]+)_([^_]+)_(.+)-([0-9a-f]{64})\.log$`)
+)
+
+// kubePod is the metadata of a pod resolved from its log directory
+type kubePod struct {
+	Namespace  string
+	Name       string
+	Containers []string
+}
+
+// KubernetesEnricher labels kubepods cgroups with their QoS class, pod UID
+// and container ID parsed from the cgroup path, and resolves the namespace,
+// pod and container names offline from the kubelet's log directory layout:
+//
+//	pods/<namespace>_<pod>_<uid>/<container>/
+//	containers/<pod>_<namespace>_<container>-<id>.log
+type KubernetesEnricher struct {
+	logs            fsys.FS
+	refreshInterval time.Duration
+	logger          *logrus.Logger
+
+	mutex      sync.RWMutex
+	pods       map[string]*kubePod
+	containers map[string]string
+}
+
+// NewKubernetesEnricher creates a Kubernetes enricher reading the kubelet
+// log directory (usually /var/log) from the given filesystem
+func NewKubernetesEnricher(logs fsys.FS, refreshInterval time.Duration, logger *logrus.Logger) *KubernetesEnricher {
+	return &KubernetesEnricher{
+		logs:            logs,
+		refreshInterval: refreshInterval,
+		logger:          logger,
+		pods:            make(map[string]*kubePod),
+		containers:      make(map[string]string),
+	}
+}
+
+// Name returns the enricher name
+func (e *KubernetesEnricher) Name() string {
+	return "kubernetes"
+}
+
+// Enrich implements cgroup.Enricher. It adds qos_class, pod_uid and
+// container_id from the path and namespace, pod_name and container_name
+// from the log directory.
+func (e *KubernetesEnricher) Enrich(cg *cgroup.CgroupInfo) {
+	qosClass, podUID, id, ok := parseKubepodsPath(cg.Path)
+	if !ok {
+		return
+	}
+
+	if qosClass != "" {
+		cg.Labels["qos_class"] = qosClass
+	}
+	if podUID == "" {
+		return
+	}
+	cg.Labels["pod_uid"] = podUID
+	if id != "" {
+		cg.Labels["container_id"] = id
+	}
+
+	e.mutex.RLock()
+	pod, ok := e.pods[podUID]
+	container := e.containers[id]
+	e.mutex.RUnlock()
+	if !ok {
+		return
+	}
+
+	cg.Labels["namespace"] = pod.Namespace
+	cg.Labels["pod_name"] = pod.Name
+	if id == "" {
+		return
+	}
+	// Without a container log symlink, a single-container pod is unambiguous
+	if container == "" && len(pod.Containers) == 1 {
+		container = pod.Containers[0]
+	}
+	if container != "" {
+		cg.Labels["container_name"] = container
+	}
+}
+
+// parseKubepodsPath extracts the QoS class, pod UID and container ID from a
+// cgroup path of the systemd or cgroupfs driver. Guaranteed pods live
+// directly below kubepods, so the kubepods cgroup itself, which holds the
+// pods of every class, has no QoS class. ok is false outside of the kubepods
+// hierarchy.
+func parseKubepodsPath(cgroupPath string) (qosClass, podUID, id string, ok bool) {
+	for _, component := range strings.Split(strings.Trim(cgroupPath, "/"), "/") {
+		if match := kubepodsSlice.FindStringSubmatch(component); match != nil {
+			ok = true
+			if match[1] != "" {
+				qosClass = match[1]
+			}
+			if match[2] != "" {
+				podUID = strings.ReplaceAll(match[2], "_", "-")
+			}
+			continue
+		}
+		if component == "kubepods" {
+			ok = true
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		if podUID == "" {
+			if component == "besteffort" || component == "burstable" {
+				qosClass = component
+			} else if match := kubepodsPod.FindStringSubmatch(component); match != nil {
+				podUID = match[1]
+			}
+		} else if match := kubeContainerScope.FindStringSubmatch(component); match != nil {
+			id = match[1]
+		} else if containerID.MatchString(component) {
+			id = component
+		}
+	}
+
+	if !ok {
+		return "", "", "", false
+	}
+	if qosClass == "" && podUID != "" {
+		qosClass = "guaranteed"
+	}
+	return qosClass, podUID, id, true
+}
+
+// Start re-reads the log directory periodically until the context is cancelled
+func (e *KubernetesEnricher) Start(ctx context.Context) error {
+	ticker := time.NewTicker(e.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.Refresh(); err != nil {
+			e.logger.WithError(err).Warn("Failed to read Kubernetes pod logs")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh rebuilds the pod and container index from the log directory
+func (e *KubernetesEnricher) Refresh() error {
+	entries, err := e.logs.ReadDir("pods")
+	if err != nil {
+		return err
+	}
+
+	pods := make(map[string]*kubePod, len(entries))
+	for _, entry := range entries {
+		// Namespace and pod names cannot contain underscores
+		parts := strings.SplitN(entry.Name(), "_", 3)
+		if !entry.IsDir() || len(parts) != 3 {
+			continue
+		}
+
+		pod := &kubePod{Namespace: parts[0], Name: parts[1]}
+		if containers, err := e.logs.ReadDir("pods/" + entry.Name()); err == nil {
+			for _, container := range containers {
+				if container.IsDir() {
+					pod.Containers = append(pod.Containers, container.Name())
+				}
+			}
+		}
+		pods[parts[2]] = pod
+	}
+
+	containers := make(map[string]string)
+	if entries, err := e.logs.ReadDir("containers"); err == nil {
+		for _, entry := range entries {
+			if match := containerLogName.FindStringSubmatch(entry.Name()); match != nil {
+				containers[match[4]] = match[3]
+			}
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		e.logger.WithError(err).Debug("Failed to read Kubernetes container logs")
+	}
+
+	e.logger.WithField("pods", len(pods)).Debug("Refreshed Kubernetes pods")
+
+	e.mutex.Lock()
+	e.pods = pods
+	e.containers = containers
+	e.mutex.Unlock()
+	return nil
+}
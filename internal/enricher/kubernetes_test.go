@@ -0,0 +1,86 @@
+package enricher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/fsys"
+)
+
+const testPodUID = "6b0c1f3e-2a4d-4c8e-9f1a-7d5e3b2c1a09"
+
+func TestParseKubepodsPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		qosClass string
+		podUID   string
+		id       string
+		ok       bool
+	}{
+		{"/system.slice/kubelet.service", "", "", "", false},
+		{"/kubepods.slice", "", "", "", true},
+		{"/kubepods", "", "", "", true},
+		{"/kubepods.slice/kubepods-burstable.slice", "burstable", "", "", true},
+		{
+			"/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod6b0c1f3e_2a4d_4c8e_9f1a_7d5e3b2c1a09.slice/cri-containerd-" + testContainerID + ".scope",
+			"burstable", testPodUID, testContainerID, true,
+		},
+		{
+			"/kubepods.slice/kubepods-pod6b0c1f3e_2a4d_4c8e_9f1a_7d5e3b2c1a09.slice",
+			"guaranteed", testPodUID, "", true,
+		},
+		{"/kubepods/besteffort/pod" + testPodUID + "/" + testContainerID, "besteffort", testPodUID, testContainerID, true},
+		{"/kubepods/pod" + testPodUID + "/" + testContainerID, "guaranteed", testPodUID, testContainerID, true},
+	}
+
+	for _, tt := range tests {
+		qosClass, podUID, id, ok := parseKubepodsPath(tt.path)
+		if qosClass != tt.qosClass || podUID != tt.podUID || id != tt.id || ok != tt.ok {
+			t.Errorf("parseKubepodsPath(%q) = %q, %q, %q, %v, want %q, %q, %q, %v",
+				tt.path, qosClass, podUID, id, ok, tt.qosClass, tt.podUID, tt.id, tt.ok)
+		}
+	}
+}
+
+func TestKubernetesEnricher(t *testing.T) {
+	logs := fsys.NewMem()
+	logs.WriteFile("pods/shop_web-7d9f_"+testPodUID+"/nginx/0.log", nil)
+	logs.WriteFile("pods/shop_web-7d9f_"+testPodUID+"/sidecar/0.log", nil)
+	logs.WriteFile("containers/web-7d9f_shop_nginx-"+testContainerID+".log", nil)
+
+	e := NewKubernetesEnricher(logs, time.Hour, logrus.New())
+	if err := e.Refresh(); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+
+	labels := enrich(e, "/kubepods/burstable/pod"+testPodUID+"/"+testContainerID)
+	want := map[string]string{
+		"qos_class":      "burstable",
+		"pod_uid":        testPodUID,
+		"container_id":   testContainerID,
+		"namespace":      "shop",
+		"pod_name":       "web-7d9f",
+		"container_name": "nginx",
+	}
+	if len(labels) != len(want) {
+		t.Errorf("Expected labels %v, got %v", want, labels)
+	}
+	for name, value := range want {
+		if labels[name] != value {
+			t.Errorf("Expected %s=%q, got %q", name, value, labels[name])
+		}
+	}
+
+	// Without a container log symlink, a multi-container pod is ambiguous
+	labels = enrich(e, "/kubepods/burstable/pod"+testPodUID+"/"+otherContainerID)
+	if _, ok := labels["container_name"]; ok || labels["pod_name"] != "web-7d9f" {
+		t.Errorf("Expected pod labels without container_name, got %v", labels)
+	}
+
+	// The parent of all pods belongs to no QoS class
+	if labels = enrich(e, "/kubepods.slice"); len(labels) != 0 {
+		t.Errorf("Expected no labels on the kubepods cgroup, got %v", labels)
+	}
+}
@@ -0,0 +1,45 @@
+package enricher
+
+import (
+	"strings"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+)
+
+// LXC cgroup prefixes of the container payload and its monitor process
+const (
+	lxcPayloadPrefix = "lxc.payload."
+	lxcMonitorPrefix = "lxc.monitor."
+)
+
+// LXCEnricher labels LXC and LXD container cgroups ("lxc.payload.<name>" and
+// "lxc.monitor.<name>") with their runtime and container name
+type LXCEnricher struct{}
+
+// NewLXCEnricher creates an LXC enricher
+func NewLXCEnricher() *LXCEnricher {
+	return &LXCEnricher{}
+}
+
+// Name returns the enricher name
+func (e *LXCEnricher) Name() string {
+	return "lxc"
+}
+
+// Enrich implements cgroup.Enricher
+func (e *LXCEnricher) Enrich(cg *cgroup.CgroupInfo) {
+	var name string
+	for _, component := range strings.Split(strings.Trim(cg.Path, "/"), "/") {
+		if strings.HasPrefix(component, lxcPayloadPrefix) {
+			name = strings.TrimPrefix(component, lxcPayloadPrefix)
+		} else if strings.HasPrefix(component, lxcMonitorPrefix) {
+			name = strings.TrimPrefix(component, lxcMonitorPrefix)
+		}
+	}
+	if name == "" {
+		return
+	}
+
+	cg.Labels["runtime"] = "lxc"
+	cg.Labels["container_name"] = name
+}
@@ -0,0 +1,23 @@
+package enricher
+
+import "testing"
+
+func TestLXCEnricher(t *testing.T) {
+	tests := map[string]string{
+		"/lxc.payload.web":                          "web",
+		"/lxc.payload.web/system.slice/ssh.service": "web",
+		"/lxc.monitor.web":                          "web",
+		"/system.slice/lxc.service":                 "",
+	}
+
+	e := NewLXCEnricher()
+	for path, want := range tests {
+		labels := enrich(e, path)
+		if labels["container_name"] != want {
+			t.Errorf("Expected container_name %q for %s, got %v", want, path, labels)
+		}
+		if want != "" && labels["runtime"] != "lxc" {
+			t.Errorf("Expected runtime lxc for %s, got %v", path, labels)
+		}
+	}
+}
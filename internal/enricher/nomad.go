@@ -0,0 +1,48 @@
+package enricher
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+)
+
+// nomadTaskScope matches the scope of a Nomad task, "<alloc>.<task>.scope",
+// where the allocation ID is a UUID and the task name may contain dots
+var nomadTaskScope = regexp.MustCompile(`^([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})\.(.+)\.scope$`)
+
+// NomadEnricher labels the cgroups of Nomad tasks below nomad.slice with
+// their allocation ID and task name
+type NomadEnricher struct{}
+
+// NewNomadEnricher creates a Nomad enricher
+func NewNomadEnricher() *NomadEnricher {
+	return &NomadEnricher{}
+}
+
+// Name returns the enricher name
+func (e *NomadEnricher) Name() string {
+	return "nomad"
+}
+
+// Enrich implements cgroup.Enricher. It adds alloc_id and task_name labels.
+func (e *NomadEnricher) Enrich(cg *cgroup.CgroupInfo) {
+	var inNomad bool
+	for _, component := range strings.Split(strings.Trim(cg.Path, "/"), "/") {
+		if component == "nomad.slice" {
+			inNomad = true
+			continue
+		}
+		if !inNomad {
+			continue
+		}
+
+		// Nomad places tasks directly in nomad.slice or, since 1.7, in its
+		// share.slice and reserve.slice children
+		if match := nomadTaskScope.FindStringSubmatch(UnescapeSystemd(component)); match != nil {
+			cg.Labels["alloc_id"] = match[1]
+			cg.Labels["task_name"] = match[2]
+			return
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package enricher
+
+import "testing"
+
+func TestNomadEnricher(t *testing.T) {
+	const allocID = "8e2b6c4f-1d3a-4b5c-9e7f-0a1b2c3d4e5f"
+
+	tests := map[string]string{
+		"/nomad.slice/" + allocID + ".web.scope":                "web",
+		"/nomad.slice/share.slice/" + allocID + ".api.v2.scope": "api.v2",
+		"/nomad.slice": "",
+		"/system.slice/" + allocID + ".web.scope": "",
+	}
+
+	e := NewNomadEnricher()
+	for path, want := range tests {
+		labels := enrich(e, path)
+		if labels["task_name"] != want {
+			t.Errorf("Expected task_name %q for %s, got %v", want, path, labels)
+		}
+		if want != "" && labels["alloc_id"] != allocID {
+			t.Errorf("Expected alloc_id %q for %s, got %v", allocID, path, labels)
+		}
+	}
+}
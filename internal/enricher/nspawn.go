@@ -0,0 +1,49 @@
+package enricher
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+)
+
+var (
+	// nspawnService matches a container started through systemd-nspawn@.service
+	nspawnService = regexp.MustCompile(`^systemd-nspawn@(.+)\.service$`)
+	// machineScope matches a machine registered with systemd-machined
+	machineScope = regexp.MustCompile(`^machine-(.+)\.scope$`)
+)
+
+// NspawnEnricher labels systemd-nspawn and machined container cgroups below
+// machine.slice with their runtime and container name
+type NspawnEnricher struct{}
+
+// NewNspawnEnricher creates a systemd-nspawn enricher
+func NewNspawnEnricher() *NspawnEnricher {
+	return &NspawnEnricher{}
+}
+
+// Name returns the enricher name
+func (e *NspawnEnricher) Name() string {
+	return "nspawn"
+}
+
+// Enrich implements cgroup.Enricher
+func (e *NspawnEnricher) Enrich(cg *cgroup.CgroupInfo) {
+	components := strings.Split(strings.Trim(cg.Path, "/"), "/")
+	if len(components) < 2 || components[0] != "machine.slice" {
+		return
+	}
+
+	name := UnescapeSystemd(components[1])
+	if match := nspawnService.FindStringSubmatch(name); match != nil {
+		name = match[1]
+	} else if match := machineScope.FindStringSubmatch(name); match != nil {
+		name = match[1]
+	} else {
+		return
+	}
+
+	cg.Labels["runtime"] = "systemd-nspawn"
+	cg.Labels["container_name"] = name
+}
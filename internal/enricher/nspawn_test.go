@@ -0,0 +1,24 @@
+package enricher
+
+import "testing"
+
+func TestNspawnEnricher(t *testing.T) {
+	tests := map[string]string{
+		`/machine.slice/systemd-nspawn@build\x2dbox.service`:           "build-box",
+		`/machine.slice/systemd-nspawn@web.service/payload/init.scope`: "web",
+		`/machine.slice/machine-build\x2dbox.scope`:                    "build-box",
+		"/machine.slice": "",
+		"/system.slice/systemd-nspawn@web.service": "",
+	}
+
+	e := NewNspawnEnricher()
+	for path, want := range tests {
+		labels := enrich(e, path)
+		if labels["container_name"] != want {
+			t.Errorf("Expected container_name %q for %s, got %v", want, path, labels)
+		}
+		if want != "" && labels["runtime"] != "systemd-nspawn" {
+			t.Errorf("Expected runtime systemd-nspawn for %s, got %v", path, labels)
+		}
+	}
+}
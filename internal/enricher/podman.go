@@ -0,0 +1,78 @@
+package enricher
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+)
+
+// libpodScope matches the scope of a Podman container (but not of its conmon
+// monitor, "libpod-conmon-<id>.scope")
+var libpodScope = regexp.MustCompile(`^libpod-([0-9a-f]{64})\.scope$`)
+
+// PodmanEnricher labels Podman container cgroups with their runtime,
+// container ID and name and, when the API is enabled, with the image.
+// Metadata comes from the Docker-compatible API of the Podman socket; without
+// it, the container name is the short ID like in "podman ps".
+type PodmanEnricher struct {
+	api *DockerEnricher
+}
+
+// NewPodmanEnricher creates a Podman enricher
+func NewPodmanEnricher(cfg config.PodmanEnricherConfig, logger *logrus.Logger) *PodmanEnricher {
+	e := &PodmanEnricher{}
+	if cfg.QueryAPI {
+		e.api = NewDockerEnricher(config.DockerEnricherConfig{
+			Enabled:         true,
+			QueryAPI:        true,
+			Socket:          cfg.Socket,
+			RefreshInterval: cfg.RefreshInterval,
+			Timeout:         cfg.Timeout,
+		}, logger)
+	}
+	return e
+}
+
+// Name returns the enricher name
+func (e *PodmanEnricher) Name() string {
+	return "podman"
+}
+
+// Enrich implements cgroup.Enricher
+func (e *PodmanEnricher) Enrich(cg *cgroup.CgroupInfo) {
+	var id string
+	for _, component := range strings.Split(strings.Trim(cg.Path, "/"), "/") {
+		if match := libpodScope.FindStringSubmatch(component); match != nil {
+			id = match[1]
+		}
+	}
+	if id == "" {
+		return
+	}
+
+	cg.Labels["runtime"] = "podman"
+	cg.Labels["container_id"] = id
+	cg.Labels["container_name"] = id[:12]
+
+	if e.api == nil {
+		return
+	}
+	if container, ok := e.api.lookup(id); ok {
+		cg.Labels["container_name"] = container.Name
+		cg.Labels["image"] = container.Image
+	}
+}
+
+// Start keeps the container cache up to date until the context is
+// cancelled. It is a no-op unless the API is enabled.
+func (e *PodmanEnricher) Start(ctx context.Context) error {
+	if e.api == nil {
+		return nil
+	}
+	return e.api.Start(ctx)
+}
@@ -0,0 +1,52 @@
+package enricher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+)
+
+func TestPodmanEnricher_PathOnly(t *testing.T) {
+	e := NewPodmanEnricher(config.PodmanEnricherConfig{Enabled: true}, logrus.New())
+
+	labels := enrich(e, "/machine.slice/libpod-"+testContainerID+".scope/container")
+	if len(labels) != 3 || labels["runtime"] != "podman" || labels["container_id"] != testContainerID || labels["container_name"] != testContainerID[:12] {
+		t.Errorf("Expected runtime, container_id and the short ID as container_name, got %v", labels)
+	}
+	if labels := enrich(e, "/machine.slice/libpod-conmon-"+testContainerID+".scope"); len(labels) != 0 {
+		t.Errorf("Expected no labels for conmon, got %v", labels)
+	}
+	if err := e.Start(context.Background()); err != nil {
+		t.Errorf("Start without the API should be a no-op, got %v", err)
+	}
+}
+
+func TestPodmanEnricher_API(t *testing.T) {
+	// Podman serves the Docker-compatible API on its socket
+	socket := newFakeDocker(t, make(chan string))
+
+	e := NewPodmanEnricher(config.PodmanEnricherConfig{
+		Enabled:         true,
+		QueryAPI:        true,
+		Socket:          socket,
+		RefreshInterval: time.Hour,
+		Timeout:         time.Second,
+	}, logrus.New())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Start(ctx)
+
+	waitFor(t, func() bool {
+		return enrich(e, "/machine.slice/libpod-"+testContainerID+".scope")["container_name"] == "web"
+	})
+
+	labels := enrich(e, "/machine.slice/libpod-"+testContainerID+".scope")
+	if labels["image"] != "nginx:1.25" || labels["runtime"] != "podman" {
+		t.Errorf("Expected podman image metadata, got %v", labels)
+	}
+}
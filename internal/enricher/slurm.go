@@ -0,0 +1,57 @@
+package enricher
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+)
+
+var (
+	// slurmstepdScope matches the scope slurmstepd moves jobs into, optionally
+	// prefixed with the node name when several slurmd run on one host
+	slurmstepdScope = regexp.MustCompile(`^(?:.+_)?slurmstepd\.scope$`)
+	// slurmJob, slurmStep and slurmTask match the levels of the job hierarchy,
+	// job_<id>/step_<n>/user/task_<n>; steps may also be named, e.g.
+	// "step_batch" or "step_extern"
+	slurmJob  = regexp.MustCompile(`^job_(\d+)$`)
+	slurmStep = regexp.MustCompile(`^step_(\w+)$`)
+	slurmTask = regexp.MustCompile(`^task_(\d+)$`)
+)
+
+// SlurmEnricher labels the cgroups of Slurm jobs with their job ID, step and
+// task, as created by the cgroup/v2 plugin below slurmstepd.scope
+type SlurmEnricher struct{}
+
+// NewSlurmEnricher creates a Slurm enricher
+func NewSlurmEnricher() *SlurmEnricher {
+	return &SlurmEnricher{}
+}
+
+// Name returns the enricher name
+func (e *SlurmEnricher) Name() string {
+	return "slurm"
+}
+
+// Enrich implements cgroup.Enricher. It adds job_id, step and task labels
+// for the levels present in the path.
+func (e *SlurmEnricher) Enrich(cg *cgroup.CgroupInfo) {
+	var inSlurm bool
+	for _, component := range strings.Split(strings.Trim(cg.Path, "/"), "/") {
+		if slurmstepdScope.MatchString(component) {
+			inSlurm = true
+			continue
+		}
+		if !inSlurm {
+			continue
+		}
+
+		if match := slurmJob.FindStringSubmatch(component); match != nil {
+			cg.Labels["job_id"] = match[1]
+		} else if match := slurmStep.FindStringSubmatch(component); match != nil {
+			cg.Labels["step"] = match[1]
+		} else if match := slurmTask.FindStringSubmatch(component); match != nil {
+			cg.Labels["task"] = match[1]
+		}
+	}
+}
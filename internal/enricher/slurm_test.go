@@ -0,0 +1,32 @@
+package enricher
+
+import "testing"
+
+func TestSlurmEnricher(t *testing.T) {
+	tests := []struct {
+		path string
+		want map[string]string
+	}{
+		{"/system.slice/slurmstepd.scope", map[string]string{}},
+		{"/system.slice/slurmstepd.scope/job_4242", map[string]string{"job_id": "4242"}},
+		{"/system.slice/slurmstepd.scope/job_4242/step_batch", map[string]string{"job_id": "4242", "step": "batch"}},
+		{
+			"/system.slice/node1_slurmstepd.scope/job_4242/step_0/user/task_3",
+			map[string]string{"job_id": "4242", "step": "0", "task": "3"},
+		},
+		{"/system.slice/job_4242", map[string]string{}},
+	}
+
+	e := NewSlurmEnricher()
+	for _, tt := range tests {
+		labels := enrich(e, tt.path)
+		if len(labels) != len(tt.want) {
+			t.Errorf("Expected labels %v for %s, got %v", tt.want, tt.path, labels)
+		}
+		for name, value := range tt.want {
+			if labels[name] != value {
+				t.Errorf("Expected %s=%q for %s, got %q", name, value, tt.path, labels[name])
+			}
+		}
+	}
+}
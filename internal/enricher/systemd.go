@@ -0,0 +1,186 @@
+package enricher
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+)
+
+// systemdUnitTypes maps the unit name suffixes that own cgroups to unit types
+var systemdUnitTypes = map[string]string{
+	".slice":   "slice",
+	".service": "service",
+	".scope":   "scope",
+	".mount":   "mount",
+}
+
+// systemdUserUnit matches the per-user slice and service manager units
+var systemdUserUnit = regexp.MustCompile(`^user(?:-|@)(\d+)\.(?:slice|service)$`)
+
+// SystemdEnricher labels cgroups with the systemd unit and slice they belong
+// to. For per-user units it also resolves the uid to a user name through a
+// local passwd file.
+type SystemdEnricher struct {
+	passwdPath string
+	logger     *logrus.Logger
+
+	mutex       sync.Mutex
+	users       map[string]string
+	passwdMTime time.Time
+}
+
+// NewSystemdEnricher creates a systemd enricher using the given passwd file
+// for user name lookups ("" disables them)
+func NewSystemdEnricher(passwdPath string, logger *logrus.Logger) *SystemdEnricher {
+	return &SystemdEnricher{
+		passwdPath: passwdPath,
+		logger:     logger,
+		users:      make(map[string]string),
+	}
+}
+
+// Name returns the enricher name
+func (e *SystemdEnricher) Name() string {
+	return "systemd"
+}
+
+// Enrich implements cgroup.Enricher. It adds unit, unit_type and slice
+// labels and, for user slices and user managers, uid and user labels.
+func (e *SystemdEnricher) Enrich(cg *cgroup.CgroupInfo) {
+	var unit, unitType, slice, parentSlice, uid string
+
+	for _, component := range strings.Split(strings.Trim(cg.Path, "/"), "/") {
+		name := UnescapeSystemd(component)
+
+		typ := systemdUnitType(name)
+		if typ == "" {
+			continue
+		}
+
+		if unitType == "slice" {
+			parentSlice = unit
+		}
+		unit, unitType, slice = name, typ, parentSlice
+
+		if match := systemdUserUnit.FindStringSubmatch(name); match != nil {
+			uid = match[1]
+		}
+	}
+
+	if unit == "" {
+		return
+	}
+	if slice == "" {
+		// Top-level units live in the root slice
+		slice = "-.slice"
+	}
+
+	cg.Labels["unit"] = unit
+	cg.Labels["unit_type"] = unitType
+	cg.Labels["slice"] = slice
+
+	if uid != "" {
+		cg.Labels["uid"] = uid
+		if user := e.lookupUser(uid); user != "" {
+			cg.Labels["user"] = user
+		}
+	}
+}
+
+// systemdUnitType returns the type of a unit name, or "" if the name is not
+// a unit that owns a cgroup
+func systemdUnitType(name string) string {
+	for suffix, typ := range systemdUnitTypes {
+		if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+			return typ
+		}
+	}
+	return ""
+}
+
+// UnescapeSystemd decodes the C-style \xNN escapes systemd uses in unit
+// names, e.g. "foo\x2dbar.service" becomes "foo-bar.service". Names that
+// would decode to invalid UTF-8, which is not a valid label value, are
+// returned unchanged.
+func UnescapeSystemd(name string) string {
+	if !strings.Contains(name, `\x`) {
+		return name
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' && i+3 < len(name) && name[i+1] == 'x' {
+			if v, err := strconv.ParseUint(name[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(name[i])
+	}
+	if !utf8.ValidString(b.String()) {
+		return name
+	}
+	return b.String()
+}
+
+// lookupUser resolves a uid through the passwd file, reloading the file
+// whenever it changes
+func (e *SystemdEnricher) lookupUser(uid string) string {
+	if e.passwdPath == "" {
+		return ""
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	info, err := os.Stat(e.passwdPath)
+	if err != nil {
+		e.logger.WithError(err).Debug("Failed to stat passwd file")
+		return e.users[uid]
+	}
+
+	if !info.ModTime().Equal(e.passwdMTime) {
+		users, err := readPasswd(e.passwdPath)
+		if err != nil {
+			e.logger.WithError(err).Debug("Failed to read passwd file")
+			return e.users[uid]
+		}
+		e.users = users
+		e.passwdMTime = info.ModTime()
+	}
+
+	return e.users[uid]
+}
+
+// readPasswd returns the uid to user name mapping of a passwd(5) file
+func readPasswd(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// name:password:uid:gid:gecos:home:shell
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 {
+			continue
+		}
+		if _, exists := users[fields[2]]; !exists {
+			users[fields[2]] = fields[0]
+		}
+	}
+	return users, nil
+}
@@ -0,0 +1,84 @@
+package enricher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+)
+
+func enrich(e cgroup.Enricher, path string) map[string]string {
+	cg := &cgroup.CgroupInfo{Path: path, Labels: map[string]string{}}
+	e.Enrich(cg)
+	return cg.Labels
+}
+
+func TestSystemdEnricher(t *testing.T) {
+	passwd := filepath.Join(t.TempDir(), "passwd")
+	if err := os.WriteFile(passwd, []byte("root:x:0:0:root:/root:/bin/sh\nalice:x:1000:1000::/home/alice:/bin/bash\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write passwd: %v", err)
+	}
+
+	e := NewSystemdEnricher(passwd, logrus.New())
+
+	tests := []struct {
+		path string
+		want map[string]string
+	}{
+		{"/", map[string]string{}},
+		{"/system.slice", map[string]string{"unit": "system.slice", "unit_type": "slice", "slice": "-.slice"}},
+		{"/system.slice/foo.service", map[string]string{"unit": "foo.service", "unit_type": "service", "slice": "system.slice"}},
+		{`/system.slice/system-getty.slice/getty@tty1.service`, map[string]string{
+			"unit": "getty@tty1.service", "unit_type": "service", "slice": "system-getty.slice",
+		}},
+		{`/system.slice/var-lib-foo\x2dbar.mount`, map[string]string{
+			"unit": "var-lib-foo-bar.mount", "unit_type": "mount", "slice": "system.slice",
+		}},
+		{"/user.slice/user-1000.slice/session-3.scope", map[string]string{
+			"unit": "session-3.scope", "unit_type": "scope", "slice": "user-1000.slice", "uid": "1000", "user": "alice",
+		}},
+		{"/user.slice/user-1000.slice/user@1000.service/app.slice/foo.service", map[string]string{
+			"unit": "foo.service", "unit_type": "service", "slice": "app.slice", "uid": "1000", "user": "alice",
+		}},
+		{"/user.slice/user-1001.slice", map[string]string{
+			"unit": "user-1001.slice", "unit_type": "slice", "slice": "user.slice", "uid": "1001",
+		}},
+		{"/system.slice/docker.service/delegated", map[string]string{
+			"unit": "docker.service", "unit_type": "service", "slice": "system.slice",
+		}},
+	}
+
+	for _, tt := range tests {
+		got := enrich(e, tt.path)
+		if len(got) != len(tt.want) {
+			t.Errorf("Enrich(%q) = %v, want %v", tt.path, got, tt.want)
+			continue
+		}
+		for name, value := range tt.want {
+			if got[name] != value {
+				t.Errorf("Enrich(%q)[%s] = %q, want %q", tt.path, name, got[name], value)
+			}
+		}
+	}
+}
+
+func TestUnescapeSystemd(t *testing.T) {
+	tests := map[string]string{
+		"foo.service":           "foo.service",
+		`foo\x2dbar.service`:    "foo-bar.service",
+		`dev-disk-by\x2duuid-1`: "dev-disk-by-uuid-1",
+		`trailing\x2`:           `trailing\x2`,
+		`bad\xzz`:               `bad\xzz`,
+		`\xff.scope`:            `\xff.scope`,
+		`caf\xc3\xa9.service`:   "café.service",
+	}
+
+	for in, want := range tests {
+		if got := UnescapeSystemd(in); got != want {
+			t.Errorf("UnescapeSystemd(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
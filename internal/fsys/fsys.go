@@ -0,0 +1,131 @@
+// Package fsys abstracts the cgroupfs and procfs trees the exporter reads
+// from, so the scanner and collectors can run against the host, an alternate
+// root or a synthetic in-memory hierarchy.
+package fsys
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FS is the read-only filesystem used for all cgroupfs and procfs access.
+// Names are slash-separated and relative to the root, as with io/fs.
+type FS interface {
+	fs.ReadDirFS
+	fs.ReadFileFS
+	fs.StatFS
+}
+
+// XattrFS is implemented by filesystems that expose extended attributes
+type XattrFS interface {
+	FS
+	// ListXattrs returns the names of the extended attributes of a file
+	ListXattrs(name string) ([]string, error)
+	// GetXattr returns the value of an extended attribute of a file
+	GetXattr(name, attr string) ([]byte, error)
+}
+
+// ListXattrs returns the extended attribute names of a file, or an error
+// wrapping errors.ErrUnsupported if the FS does not expose them
+func ListXattrs(fsys FS, name string) ([]string, error) {
+	if xfs, ok := fsys.(XattrFS); ok {
+		return xfs.ListXattrs(name)
+	}
+	return nil, &fs.PathError{Op: "listxattr", Path: name, Err: errors.ErrUnsupported}
+}
+
+// GetXattr returns an extended attribute of a file, or an error wrapping
+// errors.ErrUnsupported if the FS does not expose them
+func GetXattr(fsys FS, name, attr string) ([]byte, error) {
+	if xfs, ok := fsys.(XattrFS); ok {
+		return xfs.GetXattr(name, attr)
+	}
+	return nil, &fs.PathError{Op: "getxattr", Path: name, Err: errors.ErrUnsupported}
+}
+
+// osFS is an FS backed by a directory of the host filesystem
+type osFS struct {
+	root string
+}
+
+// NewOS returns an FS rooted at the given host directory
+func NewOS(root string) FS {
+	return &osFS{root: root}
+}
+
+// Root returns the host directory the FS is rooted at
+func (f *osFS) Root() string {
+	return f.root
+}
+
+func (f *osFS) join(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(f.root, filepath.FromSlash(name)), nil
+}
+
+// Open implements fs.FS
+func (f *osFS) Open(name string) (fs.File, error) {
+	full, err := f.join("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(full)
+}
+
+// ReadFile implements fs.ReadFileFS
+func (f *osFS) ReadFile(name string) ([]byte, error) {
+	full, err := f.join("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(full)
+}
+
+// ReadDir implements fs.ReadDirFS
+func (f *osFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	full, err := f.join("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadDir(full)
+}
+
+// Stat implements fs.StatFS
+func (f *osFS) Stat(name string) (fs.FileInfo, error) {
+	full, err := f.join("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(full)
+}
+
+// Inode returns the inode number of a file. For a cgroup directory this is
+// the kernel cgroup ID reported by bpftool, perf and eBPF helpers. It returns
+// 0 when the underlying filesystem does not expose inode numbers.
+func Inode(fi fs.FileInfo) uint64 {
+	if stat, ok := fi.Sys().(*MemStat); ok {
+		return stat.Ino
+	}
+	return sysInode(fi.Sys())
+}
+
+// Rel converts an absolute cgroup path such as "/system.slice/foo.service"
+// into the FS name of its directory ("." for the root cgroup).
+func Rel(cgroupPath string) string {
+	rel := strings.Trim(path.Clean("/"+cgroupPath), "/")
+	if rel == "" {
+		return "."
+	}
+	return rel
+}
+
+// Join joins a cgroup path and a file name into an FS name
+func Join(cgroupPath, name string) string {
+	return path.Join(Rel(cgroupPath), name)
+}
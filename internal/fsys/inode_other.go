@@ -0,0 +1,7 @@
+//go:build !unix
+
+package fsys
+
+func sysInode(interface{}) uint64 {
+	return 0
+}
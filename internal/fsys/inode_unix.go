@@ -0,0 +1,12 @@
+//go:build unix
+
+package fsys
+
+import "syscall"
+
+func sysInode(sys interface{}) uint64 {
+	if stat, ok := sys.(*syscall.Stat_t); ok {
+		return uint64(stat.Ino) //nolint:unconvert // Ino is not uint64 on every platform
+	}
+	return 0
+}
@@ -0,0 +1,383 @@
+package fsys
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemFS is an in-memory FS used to build synthetic cgroup and proc
+// hierarchies. It is safe for concurrent use.
+type MemFS struct {
+	mu      sync.RWMutex
+	root    *memNode
+	lastIno uint64
+}
+
+type memNode struct {
+	name     string
+	ino      uint64
+	dir      bool
+	data     []byte
+	modTime  time.Time
+	children map[string]*memNode
+	xattrs   map[string][]byte
+	// unreadable files fail to open with fs.ErrPermission
+	unreadable bool
+}
+
+// MemStat is the Sys() value of MemFS file infos
+type MemStat struct {
+	Ino uint64
+}
+
+// NewMem creates an empty in-memory FS
+func NewMem() *MemFS {
+	m := &MemFS{}
+	m.root = m.newDir(".")
+	return m
+}
+
+func (m *MemFS) newDir(name string) *memNode {
+	m.lastIno++
+	return &memNode{
+		name:     name,
+		ino:      m.lastIno,
+		dir:      true,
+		modTime:  time.Now(),
+		children: make(map[string]*memNode),
+	}
+}
+
+// MkdirAll creates a directory and any missing parents
+func (m *MemFS) MkdirAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.mkdirAll("mkdir", name)
+	return err
+}
+
+// WriteFile creates or replaces a file, creating missing parent directories
+func (m *MemFS) WriteFile(name string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+
+	parent, err := m.mkdirAll("write", path.Dir(name))
+	if err != nil {
+		return err
+	}
+
+	base := path.Base(name)
+	if existing, ok := parent.children[base]; ok && existing.dir {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrExist}
+	}
+
+	m.lastIno++
+	parent.children[base] = &memNode{
+		name:    base,
+		ino:     m.lastIno,
+		data:    append([]byte(nil), data...),
+		modTime: time.Now(),
+	}
+	return nil
+}
+
+// Chtimes sets the modification time of an existing file or directory
+func (m *MemFS) Chtimes(name string, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, err := m.lookup("chtimes", name)
+	if err != nil {
+		return err
+	}
+	node.modTime = mtime
+	return nil
+}
+
+// SetUnreadable makes an existing file fail to open or read with
+// fs.ErrPermission, like a file without read permission
+func (m *MemFS) SetUnreadable(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, err := m.lookup("chmod", name)
+	if err != nil {
+		return err
+	}
+	node.unreadable = true
+	return nil
+}
+
+// SetXattr sets an extended attribute of an existing file or directory
+func (m *MemFS) SetXattr(name, attr string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, err := m.lookup("setxattr", name)
+	if err != nil {
+		return err
+	}
+	if node.xattrs == nil {
+		node.xattrs = make(map[string][]byte)
+	}
+	node.xattrs[attr] = append([]byte(nil), value...)
+	return nil
+}
+
+// ListXattrs implements XattrFS
+func (m *MemFS) ListXattrs(name string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	node, err := m.lookup("listxattr", name)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make([]string, 0, len(node.xattrs))
+	for attr := range node.xattrs {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+	return attrs, nil
+}
+
+// GetXattr implements XattrFS. A missing attribute is reported as
+// fs.ErrNotExist.
+func (m *MemFS) GetXattr(name, attr string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	node, err := m.lookup("getxattr", name)
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := node.xattrs[attr]
+	if !ok {
+		return nil, &fs.PathError{Op: "getxattr", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), value...), nil
+}
+
+// RemoveAll removes a file or a directory and everything below it
+func (m *MemFS) RemoveAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+
+	parent, err := m.lookup("remove", path.Dir(name))
+	if err != nil || !parent.dir {
+		return nil
+	}
+	delete(parent.children, path.Base(name))
+	return nil
+}
+
+func (m *MemFS) mkdirAll(op, name string) (*memNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	node := m.root
+	if name == "." {
+		return node, nil
+	}
+
+	for _, elem := range strings.Split(name, "/") {
+		child, ok := node.children[elem]
+		if !ok {
+			child = m.newDir(elem)
+			node.children[elem] = child
+		} else if !child.dir {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrExist}
+		}
+		node = child
+	}
+	return node, nil
+}
+
+func (m *MemFS) lookup(op, name string) (*memNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	node := m.root
+	if name == "." {
+		return node, nil
+	}
+
+	for _, elem := range strings.Split(name, "/") {
+		if !node.dir {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		child, ok := node.children[elem]
+		if !ok {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		node = child
+	}
+	return node, nil
+}
+
+// Open implements fs.FS
+func (m *MemFS) Open(name string) (fs.File, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	node, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.dir {
+		return &memDir{info: node.info(), entries: node.entries()}, nil
+	}
+	if node.unreadable {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return &memFile{info: node.info(), Reader: bytes.NewReader(node.data)}, nil
+}
+
+// ReadFile implements fs.ReadFileFS
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	node, err := m.lookup("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	if node.dir {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+	if node.unreadable {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrPermission}
+	}
+	return append([]byte(nil), node.data...), nil
+}
+
+// ReadDir implements fs.ReadDirFS
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	node, err := m.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !node.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return node.entries(), nil
+}
+
+// Stat implements fs.StatFS
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	node, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(), nil
+}
+
+func (n *memNode) info() *memFileInfo {
+	mode := fs.FileMode(0o444)
+	if n.unreadable {
+		mode = 0
+	}
+	if n.dir {
+		mode = fs.ModeDir | 0o555
+	}
+	return &memFileInfo{
+		name:    n.name,
+		size:    int64(len(n.data)),
+		mode:    mode,
+		modTime: n.modTime,
+		stat:    &MemStat{Ino: n.ino},
+	}
+}
+
+func (n *memNode) entries() []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(n.children))
+	for _, child := range n.children {
+		entries = append(entries, fs.FileInfoToDirEntry(child.info()))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries
+}
+
+// memFileInfo implements fs.FileInfo for MemFS nodes
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	stat    *MemStat
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *memFileInfo) Sys() interface{}   { return fi.stat }
+
+// memFile is an open MemFS regular file
+type memFile struct {
+	*bytes.Reader
+	info *memFileInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+// memDir is an open MemFS directory
+type memDir struct {
+	info    *memFileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+// ReadDir implements fs.ReadDirFile
+func (d *memDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if count <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	d.offset += count
+	return remaining[:count], nil
+}
@@ -0,0 +1,76 @@
+package fsys
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMemFS(t *testing.T) {
+	mem := NewMem()
+	if err := mem.WriteFile("cgroup.controllers", []byte("cpu memory\n")); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := mem.WriteFile("system.slice/foo.service/cpu.stat", []byte("usage_usec 10\n")); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := mem.MkdirAll("user.slice"); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+
+	if err := fstest.TestFS(mem, "cgroup.controllers", "system.slice/foo.service/cpu.stat", "user.slice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mem.SetUnreadable("cgroup.controllers"); err != nil {
+		t.Fatalf("SetUnreadable failed: %v", err)
+	}
+	if _, err := mem.ReadFile("cgroup.controllers"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Expected ErrPermission for an unreadable file, got %v", err)
+	}
+
+	if err := mem.RemoveAll("system.slice"); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+	if _, err := mem.Stat("system.slice/foo.service/cpu.stat"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected ErrNotExist after RemoveAll, got %v", err)
+	}
+}
+
+func TestOSFS(t *testing.T) {
+	osfs := NewOS(".")
+	if _, err := osfs.ReadFile("fsys.go"); err != nil {
+		t.Errorf("ReadFile failed: %v", err)
+	}
+	if _, err := osfs.ReadFile("../fsys/fsys.go"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Expected ErrInvalid for path escaping the root, got %v", err)
+	}
+
+	info, err := osfs.Stat(".")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if Inode(info) == 0 {
+		t.Error("Expected a non-zero inode from the host filesystem")
+	}
+}
+
+func TestRel(t *testing.T) {
+	tests := map[string]string{
+		"/":                         ".",
+		"":                          ".",
+		"/system.slice":             "system.slice",
+		"/system.slice/foo.service": "system.slice/foo.service",
+	}
+
+	for in, want := range tests {
+		if got := Rel(in); got != want {
+			t.Errorf("Rel(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	if got := Join("/", "cgroup.procs"); got != "cgroup.procs" {
+		t.Errorf("Join on the root cgroup = %q, want %q", got, "cgroup.procs")
+	}
+}
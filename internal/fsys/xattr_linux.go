@@ -0,0 +1,74 @@
+//go:build linux
+
+package fsys
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"syscall"
+)
+
+// ListXattrs implements XattrFS
+func (f *osFS) ListXattrs(name string) ([]string, error) {
+	full, err := f.join("listxattr", name)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := readXattr(func(dest []byte) (int, error) {
+		return syscall.Listxattr(full, dest)
+	})
+	if err != nil {
+		return nil, &fs.PathError{Op: "listxattr", Path: name, Err: err}
+	}
+
+	// The list is a sequence of NUL-terminated names
+	var attrs []string
+	for _, attr := range strings.Split(string(buf), "\x00") {
+		if attr != "" {
+			attrs = append(attrs, attr)
+		}
+	}
+	return attrs, nil
+}
+
+// GetXattr implements XattrFS
+func (f *osFS) GetXattr(name, attr string) ([]byte, error) {
+	full, err := f.join("getxattr", name)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := readXattr(func(dest []byte) (int, error) {
+		return syscall.Getxattr(full, attr, dest)
+	})
+	if err != nil {
+		return nil, &fs.PathError{Op: "getxattr", Path: name, Err: err}
+	}
+	return buf, nil
+}
+
+// readXattr queries the size of an attribute value or list and reads it,
+// retrying if it grows in between
+func readXattr(read func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := read(nil)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return nil, nil
+		}
+
+		buf := make([]byte, size)
+		n, err := read(buf)
+		if errors.Is(err, syscall.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package fsys
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// ListXattrs implements XattrFS
+func (f *osFS) ListXattrs(name string) ([]string, error) {
+	return nil, &fs.PathError{Op: "listxattr", Path: name, Err: errors.ErrUnsupported}
+}
+
+// GetXattr implements XattrFS
+func (f *osFS) GetXattr(name, attr string) ([]byte, error) {
+	return nil, &fs.PathError{Op: "getxattr", Path: name, Err: errors.ErrUnsupported}
+}
@@ -0,0 +1,351 @@
+package otlp
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+)
+
+// scopeName is the instrumentation scope of the exported metrics
+const scopeName = "github.com/stillalive04/prometheus-cgroup-v2-exporter"
+
+// converter turns gathered metric families into OTLP resource metrics with
+// one resource per cgroup. The identifying and enrichment labels of a
+// cgroup become resource attributes, the metric-specific labels data point
+// attributes. Series that belong to no cgroup, such as the exporter's own
+// metrics, go to a resource without cgroup attributes.
+//
+// Series are matched to cgroups by their cgroup labels, including the ones
+// renamed with a "cgroup_" prefix for clashing with a metric label. Series
+// changed by metric relabeling match by a label that identifies a single
+// cgroup, such as its path; labels whose value differs from the cgroup's
+// stay data point attributes.
+type converter struct {
+	// attributes are the resource attributes of every resource
+	attributes []*commonpb.KeyValue
+	version    string
+	// start is the start time of cumulative points without a created
+	// timestamp
+	start time.Time
+	now   time.Time
+
+	// cgroups maps the label key of each cgroup to its resource
+	cgroups map[string]*resource
+	// unique maps the key of each label pair of only one cgroup to its
+	// resource
+	unique map[string]*resource
+	// cgroupLabels are the label names used by any cgroup
+	cgroupLabels map[string]bool
+	host         *resource
+	resources    []*resource
+}
+
+// resource collects the metrics of a resource by name
+type resource struct {
+	pb      *metricspb.ResourceMetrics
+	metrics map[string]*metricspb.Metric
+	// labels are the labels of the cgroup, nil for the host
+	labels map[string]string
+}
+
+func newConverter(attributes map[string]string, version string, start, now time.Time, cgroups []*cgroup.CgroupInfo) *converter {
+	c := &converter{
+		attributes:   keyValues(attributes),
+		version:      version,
+		start:        start,
+		now:          now,
+		cgroups:      make(map[string]*resource),
+		unique:       make(map[string]*resource),
+		cgroupLabels: make(map[string]bool),
+	}
+
+	shared := make(map[string]bool)
+	for _, cg := range cgroups {
+		labels := cgroupLabels(cg.Labels)
+		for name := range labels {
+			c.cgroupLabels[name] = true
+		}
+		attributes := keyValues(labels)
+		attributes = append(attributes,
+			stringKeyValue("cgroup.path", cg.Path),
+			&commonpb.KeyValue{Key: "cgroup.id", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(cg.ID)}}},
+		)
+		r := c.newResource(attributes)
+		r.labels = labels
+		c.cgroups[labelKey(labels)] = r
+		for name, value := range labels {
+			key := labelKey(map[string]string{name: value})
+			if _, ok := c.unique[key]; ok {
+				shared[key] = true
+			}
+			c.unique[key] = r
+		}
+	}
+	for key := range shared {
+		delete(c.unique, key)
+	}
+	c.host = c.newResource(nil)
+	return c
+}
+
+func (c *converter) newResource(attributes []*commonpb.KeyValue) *resource {
+	r := &resource{
+		pb: &metricspb.ResourceMetrics{
+			Resource: &resourcepb.Resource{Attributes: append(append([]*commonpb.KeyValue(nil), c.attributes...), attributes...)},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope: &commonpb.InstrumentationScope{Name: scopeName, Version: c.version},
+			}},
+		},
+		metrics: make(map[string]*metricspb.Metric),
+	}
+	c.resources = append(c.resources, r)
+	return r
+}
+
+// convert adds the metric families and returns the non-empty resources
+func (c *converter) convert(families []*dto.MetricFamily) []*metricspb.ResourceMetrics {
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			c.add(family, m)
+		}
+	}
+
+	var result []*metricspb.ResourceMetrics
+	for _, r := range c.resources {
+		if len(r.metrics) == 0 {
+			continue
+		}
+		scope := r.pb.ScopeMetrics[0]
+		for _, metric := range r.metrics {
+			scope.Metrics = append(scope.Metrics, metric)
+		}
+		sort.Slice(scope.Metrics, func(i, j int) bool { return scope.Metrics[i].Name < scope.Metrics[j].Name })
+		result = append(result, r.pb)
+	}
+	return result
+}
+
+// add converts a metric into a data point of the resource of its cgroup
+func (c *converter) add(family *dto.MetricFamily, m *dto.Metric) {
+	r, attributes := c.resourceOf(m.GetLabel())
+
+	metric, ok := r.metrics[family.GetName()]
+	if !ok {
+		metric = &metricspb.Metric{Name: family.GetName(), Description: family.GetHelp()}
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			metric.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+			}}
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			metric.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{}}
+		case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+			metric.Data = &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			}}
+		case dto.MetricType_SUMMARY:
+			metric.Data = &metricspb.Metric_Summary{Summary: &metricspb.Summary{}}
+		default:
+			return
+		}
+		r.metrics[family.GetName()] = metric
+	}
+
+	now := c.now
+	if m.TimestampMs != nil {
+		now = time.UnixMilli(m.GetTimestampMs())
+	}
+	timeNano := uint64(now.UnixNano())
+
+	switch data := metric.Data.(type) {
+	case *metricspb.Metric_Sum:
+		data.Sum.DataPoints = append(data.Sum.DataPoints, &metricspb.NumberDataPoint{
+			Attributes:        attributes,
+			StartTimeUnixNano: c.startTime(m.GetCounter().GetCreatedTimestamp().AsTime()),
+			TimeUnixNano:      timeNano,
+			Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: m.GetCounter().GetValue()},
+		})
+	case *metricspb.Metric_Gauge:
+		value := m.GetGauge().GetValue()
+		if family.GetType() == dto.MetricType_UNTYPED {
+			value = m.GetUntyped().GetValue()
+		}
+		data.Gauge.DataPoints = append(data.Gauge.DataPoints, &metricspb.NumberDataPoint{
+			Attributes:   attributes,
+			TimeUnixNano: timeNano,
+			Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+		})
+	case *metricspb.Metric_Histogram:
+		data.Histogram.DataPoints = append(data.Histogram.DataPoints, c.histogramPoint(m.GetHistogram(), attributes, timeNano))
+	case *metricspb.Metric_Summary:
+		summary := m.GetSummary()
+		point := &metricspb.SummaryDataPoint{
+			Attributes:        attributes,
+			StartTimeUnixNano: c.startTime(summary.GetCreatedTimestamp().AsTime()),
+			TimeUnixNano:      timeNano,
+			Count:             summary.GetSampleCount(),
+			Sum:               summary.GetSampleSum(),
+		}
+		for _, q := range summary.GetQuantile() {
+			point.QuantileValues = append(point.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+				Quantile: q.GetQuantile(),
+				Value:    q.GetValue(),
+			})
+		}
+		data.Summary.DataPoints = append(data.Summary.DataPoints, point)
+	}
+}
+
+// histogramPoint converts the cumulative buckets of a Prometheus histogram
+// into the per-bucket counts of OTLP
+func (c *converter) histogramPoint(h *dto.Histogram, attributes []*commonpb.KeyValue, timeNano uint64) *metricspb.HistogramDataPoint {
+	sum := h.GetSampleSum()
+	point := &metricspb.HistogramDataPoint{
+		Attributes:        attributes,
+		StartTimeUnixNano: c.startTime(h.GetCreatedTimestamp().AsTime()),
+		TimeUnixNano:      timeNano,
+		Count:             h.GetSampleCount(),
+		Sum:               &sum,
+	}
+	var previous uint64
+	for _, b := range h.GetBucket() {
+		if math.IsInf(b.GetUpperBound(), +1) {
+			break
+		}
+		point.ExplicitBounds = append(point.ExplicitBounds, b.GetUpperBound())
+		point.BucketCounts = append(point.BucketCounts, b.GetCumulativeCount()-previous)
+		previous = b.GetCumulativeCount()
+	}
+	point.BucketCounts = append(point.BucketCounts, h.GetSampleCount()-previous)
+	return point
+}
+
+// resourceOf returns the resource of a series and its data point attributes
+func (c *converter) resourceOf(pairs []*dto.LabelPair) (*resource, []*commonpb.KeyValue) {
+	names := make(map[string]bool, len(pairs))
+	for _, pair := range pairs {
+		names[pair.GetName()] = true
+	}
+
+	identifying := make(map[string]string)
+	for _, pair := range pairs {
+		if name, ok := c.cgroupLabel(pair.GetName(), names); ok && pair.GetValue() != "" {
+			identifying[name] = pair.GetValue()
+		}
+	}
+	r := c.match(identifying)
+
+	// Series not matching a cgroup keep all labels
+	var attributes []*commonpb.KeyValue
+	for _, pair := range pairs {
+		if r != c.host {
+			if name, ok := c.cgroupLabel(pair.GetName(), names); ok && r.labels[name] == pair.GetValue() {
+				continue
+			}
+		}
+		attributes = append(attributes, stringKeyValue(pair.GetName(), pair.GetValue()))
+	}
+	return r, attributes
+}
+
+// cgroupLabel returns the cgroup label of a series label. The collectors
+// prefix a cgroup label with "cgroup_" if the metric has a label of the same
+// name, e.g. the "type" of the hierarchical scheme on pressure metrics.
+func (c *converter) cgroupLabel(name string, names map[string]bool) (string, bool) {
+	if c.cgroupLabels[name] && !names["cgroup_"+name] {
+		return name, true
+	}
+	if original, ok := strings.CutPrefix(name, "cgroup_"); ok && c.cgroupLabels[original] && names[original] {
+		return original, true
+	}
+	return "", false
+}
+
+// match returns the resource of the cgroup with the given labels, or the
+// host resource if there is none or the labels identify different cgroups
+func (c *converter) match(identifying map[string]string) *resource {
+	if len(identifying) == 0 {
+		return c.host
+	}
+	if r, ok := c.cgroups[labelKey(identifying)]; ok {
+		return r
+	}
+
+	var match *resource
+	for name, value := range identifying {
+		r, ok := c.unique[labelKey(map[string]string{name: value})]
+		if !ok {
+			continue
+		}
+		if match != nil && r != match {
+			return c.host
+		}
+		match = r
+	}
+	if match == nil {
+		return c.host
+	}
+	return match
+}
+
+// startTime returns the start of a cumulative point, the created timestamp
+// if known
+func (c *converter) startTime(created time.Time) uint64 {
+	if created.Unix() <= 0 {
+		created = c.start
+	}
+	return uint64(created.UnixNano())
+}
+
+// cgroupLabels returns the exported labels of a cgroup, without meta labels
+// and empty values
+func cgroupLabels(labels map[string]string) map[string]string {
+	result := make(map[string]string, len(labels))
+	for name, value := range labels {
+		if value != "" && !strings.HasPrefix(name, "__") {
+			result[name] = value
+		}
+	}
+	return result
+}
+
+// labelKey returns a key identifying a label set
+func labelKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var key strings.Builder
+	for _, name := range names {
+		key.WriteString(name)
+		key.WriteByte(0xff)
+		key.WriteString(labels[name])
+		key.WriteByte(0xff)
+	}
+	return key.String()
+}
+
+// keyValues returns string attributes sorted by key
+func keyValues(attributes map[string]string) []*commonpb.KeyValue {
+	result := make([]*commonpb.KeyValue, 0, len(attributes))
+	for key, value := range attributes {
+		result = append(result, stringKeyValue(key, value))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+func stringKeyValue(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
@@ -0,0 +1,221 @@
+// Package otlp exports the metrics of the exporter to an OpenTelemetry
+// Collector with OTLP over HTTP or gRPC.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/version"
+	"github.com/sirupsen/logrus"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+)
+
+// Exporter periodically gathers the metrics and exports them with OTLP,
+// with one resource per cgroup of the latest scan. Counters and histograms
+// are cumulative, so a failed export loses no data and is not retried.
+type Exporter struct {
+	config   config.OTLPConfig
+	gatherer prometheus.Gatherer
+	scanner  *cgroup.Scanner
+	logger   *logrus.Logger
+	// attributes are the resource attributes of every resource
+	attributes map[string]string
+	start      time.Time
+
+	// export sends a request with the configured protocol
+	export func(ctx context.Context, request *colmetricspb.ExportMetricsServiceRequest) error
+	conn   *grpc.ClientConn
+
+	registry        *prometheus.Registry
+	pointsExported  prometheus.Counter
+	exportsFailed   prometheus.Counter
+	lastExportTime  prometheus.Gauge
+	exportDurations prometheus.Histogram
+}
+
+// NewExporter creates an exporter of the metrics of gatherer. scanner must
+// be the scanner shared with the collectors; its latest scan maps series to
+// cgroups. The exporter's own metrics are exported along with them.
+func NewExporter(cfg config.OTLPConfig, gatherer prometheus.Gatherer, scanner *cgroup.Scanner, logger *logrus.Logger) (*Exporter, error) {
+	e := &Exporter{
+		config:  cfg,
+		scanner: scanner,
+		logger:  logger,
+		attributes: map[string]string{
+			"service.name":    "prometheus-cgroup-v2-exporter",
+			"service.version": version.Version,
+		},
+		start: time.Now(),
+
+		registry: prometheus.NewRegistry(),
+		pointsExported: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheus_cgroup_v2_exporter_otlp_exported_points_total",
+			Help: "Total number of data points exported with OTLP",
+		}),
+		exportsFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheus_cgroup_v2_exporter_otlp_failed_exports_total",
+			Help: "Total number of failed OTLP export requests",
+		}),
+		lastExportTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "prometheus_cgroup_v2_exporter_otlp_last_export_timestamp_seconds",
+			Help: "Unix timestamp of the last successful OTLP export",
+		}),
+		exportDurations: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "prometheus_cgroup_v2_exporter_otlp_export_duration_seconds",
+			Help:    "Duration of OTLP export requests",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+	e.registry.MustRegister(e.pointsExported, e.exportsFailed, e.lastExportTime, e.exportDurations)
+	e.gatherer = prometheus.Gatherers{gatherer, e.registry}
+
+	if hostname, err := os.Hostname(); err == nil {
+		e.attributes["host.name"] = hostname
+	}
+	for key, value := range cfg.ResourceAttributes {
+		e.attributes[key] = value
+	}
+
+	if cfg.Protocol == "grpc" {
+		creds := credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+		if cfg.Insecure {
+			creds = insecure.NewCredentials()
+		}
+		conn, err := grpc.Dial(cfg.Endpoint, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to OTLP endpoint %s: %w", cfg.Endpoint, err)
+		}
+		e.conn = conn
+		e.export = e.exportGRPC
+	} else {
+		e.export = e.exportHTTP
+	}
+	return e, nil
+}
+
+// Start exports the metrics every interval until ctx is cancelled
+func (e *Exporter) Start(ctx context.Context) error {
+	e.logger.WithFields(logrus.Fields{
+		"endpoint": e.config.Endpoint,
+		"protocol": e.config.Protocol,
+		"interval": e.config.Interval,
+	}).Info("Starting OTLP metrics export")
+	if e.conn != nil {
+		defer e.conn.Close()
+	}
+
+	ticker := time.NewTicker(e.config.Interval)
+	defer ticker.Stop()
+	for {
+		if err := e.Export(ctx); err != nil {
+			e.logger.WithError(err).Error("Failed to export metrics with OTLP")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Export gathers the metrics and exports them once
+func (e *Exporter) Export(ctx context.Context) error {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		// Like promhttp with ContinueOnError, export what could be gathered
+		e.logger.WithError(err).Warn("Failed to gather some metrics for OTLP")
+	}
+	cgroups, _ := e.scanner.Snapshot()
+
+	request := &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: newConverter(e.attributes, version.Version, e.start, time.Now(), cgroups).convert(families),
+	}
+	points := countPoints(request)
+
+	ctx, cancel := context.WithTimeout(ctx, e.config.Timeout)
+	defer cancel()
+	start := time.Now()
+	err = e.export(ctx, request)
+	e.exportDurations.Observe(time.Since(start).Seconds())
+	if err != nil {
+		e.exportsFailed.Inc()
+		return err
+	}
+
+	e.pointsExported.Add(float64(points))
+	e.lastExportTime.SetToCurrentTime()
+	return nil
+}
+
+// exportHTTP sends a request with OTLP/HTTP in the binary protobuf encoding
+func (e *Exporter) exportHTTP(ctx context.Context, request *colmetricspb.ExportMetricsServiceRequest) error {
+	body, err := proto.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to encode OTLP request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "prometheus-cgroup-v2-exporter/"+version.Version)
+	for key, value := range e.config.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+}
+
+// exportGRPC sends a request with OTLP/gRPC
+func (e *Exporter) exportGRPC(ctx context.Context, request *colmetricspb.ExportMetricsServiceRequest) error {
+	if len(e.config.Headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(e.config.Headers))
+	}
+	_, err := colmetricspb.NewMetricsServiceClient(e.conn).Export(ctx, request)
+	return err
+}
+
+// countPoints returns the number of data points of a request
+func countPoints(request *colmetricspb.ExportMetricsServiceRequest) int {
+	points := 0
+	for _, resource := range request.GetResourceMetrics() {
+		for _, scope := range resource.GetScopeMetrics() {
+			for _, metric := range scope.GetMetrics() {
+				points += len(metric.GetGauge().GetDataPoints()) +
+					len(metric.GetSum().GetDataPoints()) +
+					len(metric.GetHistogram().GetDataPoints()) +
+					len(metric.GetSummary().GetDataPoints())
+			}
+		}
+	}
+	return points
+}
@@ -0,0 +1,300 @@
+package otlp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/collector"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/fsys"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/relabel"
+)
+
+// newTestGatherer returns a gatherer of a CPU collector reading two cgroups
+// and a histogram, and the scanner shared with the collector
+func newTestGatherer(t *testing.T) (prometheus.Gatherer, *cgroup.Scanner) {
+	t.Helper()
+
+	cgroupFS := fsys.NewMem()
+	for name, data := range map[string]string{
+		"cgroup.controllers":                          "cpu\n",
+		"system.slice/cgroup.controllers":             "cpu\n",
+		"system.slice/cpu.stat":                       "usage_usec 3000000\nuser_usec 2000000\nsystem_usec 1000000\n",
+		"system.slice/foo.service/cgroup.controllers": "cpu\n",
+		"system.slice/foo.service/cpu.stat":           "usage_usec 1500000\nuser_usec 1000000\nsystem_usec 500000\n",
+	} {
+		if err := cgroupFS.WriteFile(name, []byte(data)); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	cfg := &config.Config{
+		Collectors: config.CollectorsConfig{CPU: config.CPUCollectorConfig{Enabled: true}},
+		Advanced:   config.AdvancedConfig{MaxCgroups: 100},
+	}
+	sources := collector.Sources{Cgroup: cgroupFS, Proc: fsys.NewMem()}
+	sources.Scanner = collector.NewScanner(cfg, sources, logrus.New())
+	collectors, err := collector.NewCollectorsWithSources(cfg, sources, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create collectors: %v", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	for _, c := range collectors {
+		registry.MustRegister(c)
+	}
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_duration_seconds", Help: "Test histogram.", Buckets: []float64{0.1, 1}})
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(5)
+	registry.MustRegister(histogram)
+	return collector.NewGatherer(sources.Scanner, registry), sources.Scanner
+}
+
+func newTestConfig(endpoint, protocol string) config.OTLPConfig {
+	return config.OTLPConfig{
+		Endpoint:           endpoint,
+		Protocol:           protocol,
+		Insecure:           true,
+		Headers:            map[string]string{"x-tenant": "edge"},
+		Interval:           time.Hour,
+		Timeout:            5 * time.Second,
+		ResourceAttributes: map[string]string{"deployment.environment": "test"},
+	}
+}
+
+// attribute returns the string value of an attribute
+func attribute(attributes []*commonpb.KeyValue, key string) (string, bool) {
+	for _, kv := range attributes {
+		if kv.GetKey() == key {
+			return kv.GetValue().GetStringValue(), true
+		}
+	}
+	return "", false
+}
+
+// findResource returns the resource metrics with the given cgroup path, or
+// the resource without cgroup for an empty path
+func findResource(request *colmetricspb.ExportMetricsServiceRequest, cgroupPath string) *metricspb.ResourceMetrics {
+	for _, rm := range request.GetResourceMetrics() {
+		if path, _ := attribute(rm.GetResource().GetAttributes(), "cgroup.path"); path == cgroupPath {
+			return rm
+		}
+	}
+	return nil
+}
+
+func findMetric(rm *metricspb.ResourceMetrics, name string) *metricspb.Metric {
+	for _, metric := range rm.GetScopeMetrics()[0].GetMetrics() {
+		if metric.GetName() == name {
+			return metric
+		}
+	}
+	return nil
+}
+
+func TestExporter_HTTP(t *testing.T) {
+	requests := make(chan *colmetricspb.ExportMetricsServiceRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/x-protobuf" || r.Header.Get("X-Tenant") != "edge" {
+			t.Errorf("Unexpected headers: %v", r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		request := &colmetricspb.ExportMetricsServiceRequest{}
+		if err := proto.Unmarshal(body, request); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		requests <- request
+	}))
+	defer server.Close()
+
+	gatherer, scanner := newTestGatherer(t)
+	e, err := NewExporter(newTestConfig(server.URL+"/v1/metrics", "http/protobuf"), gatherer, scanner, logrus.New())
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	if err := e.Export(context.Background()); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	request := <-requests
+
+	service := findResource(request, "/system.slice/foo.service")
+	if service == nil {
+		t.Fatalf("Expected a resource of /system.slice/foo.service, got %v", request)
+	}
+	attributes := service.GetResource().GetAttributes()
+	for key, want := range map[string]string{
+		"cgroup":                 "system.slice.foo.service",
+		"service.name":           "prometheus-cgroup-v2-exporter",
+		"deployment.environment": "test",
+	} {
+		if got, _ := attribute(attributes, key); got != want {
+			t.Errorf("Expected resource attribute %s=%q, got %q", key, want, got)
+		}
+	}
+
+	usage := findMetric(service, "cgroup_cpu_usage_seconds_total")
+	sum := usage.GetSum()
+	if sum == nil || !sum.GetIsMonotonic() || sum.GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+		t.Fatalf("Expected a cumulative monotonic sum, got %v", usage)
+	}
+	for _, point := range sum.GetDataPoints() {
+		if _, ok := attribute(point.GetAttributes(), "cgroup"); ok {
+			t.Error("Expected the cgroup labels only on the resource")
+		}
+		mode, _ := attribute(point.GetAttributes(), "mode")
+		if want := map[string]float64{"user": 1, "system": 0.5}[mode]; point.GetAsDouble() != want {
+			t.Errorf("Expected %s time %v, got %v", mode, want, point.GetAsDouble())
+		}
+		if point.GetStartTimeUnixNano() == 0 || point.GetStartTimeUnixNano() > point.GetTimeUnixNano() {
+			t.Errorf("Expected a start time before the point, got %d", point.GetStartTimeUnixNano())
+		}
+	}
+
+	host := findResource(request, "")
+	if host == nil {
+		t.Fatal("Expected a resource for the series without cgroup")
+	}
+	histogram := findMetric(host, "test_duration_seconds").GetHistogram()
+	if histogram == nil || len(histogram.GetDataPoints()) != 1 {
+		t.Fatalf("Expected a histogram, got %v", histogram)
+	}
+	point := histogram.GetDataPoints()[0]
+	if counts := point.GetBucketCounts(); len(counts) != 3 || counts[0] != 1 || counts[1] != 1 || counts[2] != 1 {
+		t.Errorf("Expected the bucket counts [1 1 1], got %v", counts)
+	}
+	if findMetric(host, "prometheus_cgroup_v2_exporter_otlp_exported_points_total") == nil {
+		t.Error("Expected the OTLP metrics to be exported")
+	}
+}
+
+// metricsService is a fake OTLP/gRPC metrics service
+type metricsService struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	requests chan *colmetricspb.ExportMetricsServiceRequest
+	metadata chan metadata.MD
+}
+
+func (s *metricsService) Export(ctx context.Context, request *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.metadata <- md
+	s.requests <- request
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+func TestExporter_GRPC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	service := &metricsService{
+		requests: make(chan *colmetricspb.ExportMetricsServiceRequest, 1),
+		metadata: make(chan metadata.MD, 1),
+	}
+	server := grpc.NewServer()
+	colmetricspb.RegisterMetricsServiceServer(server, service)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	gatherer, scanner := newTestGatherer(t)
+	e, err := NewExporter(newTestConfig(listener.Addr().String(), "grpc"), gatherer, scanner, logrus.New())
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	defer e.conn.Close()
+	if err := e.Export(context.Background()); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	if md := <-service.metadata; len(md.Get("x-tenant")) != 1 || md.Get("x-tenant")[0] != "edge" {
+		t.Errorf("Expected the x-tenant header, got %v", md)
+	}
+	if request := <-service.requests; findResource(request, "/system.slice") == nil {
+		t.Errorf("Expected a resource of /system.slice, got %v", request)
+	}
+}
+
+func TestConverter_RenamedLabels(t *testing.T) {
+	cgroupFS := fsys.NewMem()
+	for name, data := range map[string]string{
+		"cgroup.controllers":                          "cpu\n",
+		"system.slice/cgroup.controllers":             "cpu\n",
+		"system.slice/foo.service/cgroup.controllers": "cpu\n",
+		"system.slice/foo.service/cpu.stat":           "usage_usec 1500000\nuser_usec 1000000\nsystem_usec 500000\n",
+		"system.slice/foo.service/cpu.pressure":       "some avg10=0.00 avg60=0.00 avg300=0.00 total=250000\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n",
+	} {
+		if err := cgroupFS.WriteFile(name, []byte(data)); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	cfg := &config.Config{
+		Cgroup:     config.CgroupConfig{LabelScheme: "hierarchical"},
+		Collectors: config.CollectorsConfig{CPU: config.CPUCollectorConfig{Enabled: true, IncludePressure: true}},
+		MetricRelabelConfigs: []relabel.Config{
+			{SourceLabels: []string{"__name__", "leaf"}, Regex: "cgroup_cpu_user_seconds_total;(.+)\\.service", TargetLabel: "leaf"},
+		},
+		Advanced: config.AdvancedConfig{MaxCgroups: 100},
+	}
+	sources := collector.Sources{Cgroup: cgroupFS, Proc: fsys.NewMem()}
+	sources.Scanner = collector.NewScanner(cfg, sources, logrus.New())
+	collectors, err := collector.NewCollectorsWithSources(cfg, sources, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create collectors: %v", err)
+	}
+	registry := prometheus.NewRegistry()
+	for _, c := range collectors {
+		registry.MustRegister(c)
+	}
+	families, err := collector.NewGatherer(sources.Scanner, registry).Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	cgroups, _ := sources.Scanner.Snapshot()
+	request := &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: newConverter(nil, "", time.Now(), time.Now(), cgroups).convert(families),
+	}
+
+	service := findResource(request, "/system.slice/foo.service")
+	if service == nil {
+		t.Fatalf("Expected a resource of /system.slice/foo.service, got %v", request)
+	}
+
+	// The cgroup type is renamed to cgroup_type next to the pressure type
+	pressure := findMetric(service, "cgroup_cpu_pressure_seconds_total")
+	if pressure == nil {
+		t.Fatal("Expected the pressure on the resource of the cgroup")
+	}
+	for _, point := range pressure.GetSum().GetDataPoints() {
+		if _, ok := attribute(point.GetAttributes(), "cgroup_type"); ok {
+			t.Error("Expected the cgroup type only on the resource")
+		}
+		if kind, _ := attribute(point.GetAttributes(), "type"); kind != "some" && kind != "full" {
+			t.Errorf("Expected the pressure type as data point attribute, got %q", kind)
+		}
+	}
+
+	// A relabeled cgroup label matches by the path and stays on the point
+	user := findMetric(service, "cgroup_cpu_user_seconds_total")
+	if user == nil {
+		t.Fatal("Expected the relabeled series on the resource of the cgroup")
+	}
+	if leaf, _ := attribute(user.GetSum().GetDataPoints()[0].GetAttributes(), "leaf"); leaf != "foo" {
+		t.Errorf("Expected the relabeled leaf as data point attribute, got %q", leaf)
+	}
+}
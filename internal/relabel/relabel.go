@@ -0,0 +1,215 @@
+// Package relabel implements Prometheus-compatible relabeling rules for the
+// label sets of discovered cgroups.
+package relabel
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Relabeling actions
+const (
+	Replace  = "replace"
+	Keep     = "keep"
+	Drop     = "drop"
+	LabelMap = "labelmap"
+	HashMod  = "hashmod"
+)
+
+// Defaults of unset rule fields, as in Prometheus
+const (
+	DefaultSeparator   = ";"
+	DefaultRegex       = "(.*)"
+	DefaultReplacement = "$1"
+)
+
+// MetaPrefix marks labels that are available to rules but removed afterwards
+const MetaPrefix = "__"
+
+// NameLabel holds the metric name during metric relabeling
+const NameLabel = "__name__"
+
+// labelName matches valid Prometheus label names
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Config is a relabeling rule as written in the configuration file
+type Config struct {
+	SourceLabels []string `mapstructure:"source_labels"`
+	Separator    string   `mapstructure:"separator"`
+	Regex        string   `mapstructure:"regex"`
+	Modulus      uint64   `mapstructure:"modulus"`
+	TargetLabel  string   `mapstructure:"target_label"`
+	// Replacement is a pointer so an explicitly empty replacement, which
+	// deletes the target label, can be told apart from the "$1" default
+	Replacement *string `mapstructure:"replacement"`
+	Action      string  `mapstructure:"action"`
+}
+
+// Rule is a compiled relabeling rule
+type Rule struct {
+	sourceLabels []string
+	separator    string
+	regex        *regexp.Regexp
+	modulus      uint64
+	targetLabel  string
+	replacement  string
+	action       string
+}
+
+// Compile validates relabeling rules and fills in their defaults
+func Compile(configs []Config) ([]*Rule, error) {
+	rules := make([]*Rule, 0, len(configs))
+	for i, cfg := range configs {
+		rule, err := compile(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func compile(cfg Config) (*Rule, error) {
+	rule := &Rule{
+		sourceLabels: cfg.SourceLabels,
+		separator:    cfg.Separator,
+		modulus:      cfg.Modulus,
+		targetLabel:  cfg.TargetLabel,
+		replacement:  DefaultReplacement,
+		action:       strings.ToLower(cfg.Action),
+	}
+	if rule.separator == "" {
+		rule.separator = DefaultSeparator
+	}
+	if cfg.Replacement != nil {
+		rule.replacement = *cfg.Replacement
+	}
+	if rule.action == "" {
+		rule.action = Replace
+	}
+
+	expr := cfg.Regex
+	if expr == "" {
+		expr = DefaultRegex
+	}
+	regex, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %q: %w", expr, err)
+	}
+	rule.regex = regex
+
+	switch rule.action {
+	case Replace:
+		if rule.targetLabel == "" {
+			return nil, fmt.Errorf("target_label is required for action %q", rule.action)
+		}
+	case HashMod:
+		if rule.targetLabel == "" {
+			return nil, fmt.Errorf("target_label is required for action %q", rule.action)
+		}
+		if rule.modulus == 0 {
+			return nil, fmt.Errorf("modulus must be positive for action %q", rule.action)
+		}
+	case Keep, Drop:
+		if len(rule.sourceLabels) == 0 {
+			return nil, fmt.Errorf("source_labels are required for action %q", rule.action)
+		}
+	case LabelMap:
+	default:
+		return nil, fmt.Errorf("unknown action %q", cfg.Action)
+	}
+
+	return rule, nil
+}
+
+// Process applies the rules in order to a copy of the label set. It returns
+// the resulting labels without meta labels, or false if a rule dropped the
+// label set.
+func Process(labels map[string]string, rules []*Rule) (map[string]string, bool) {
+	result := make(map[string]string, len(labels))
+	for name, value := range labels {
+		result[name] = value
+	}
+
+	for _, rule := range rules {
+		if !rule.apply(result) {
+			return nil, false
+		}
+	}
+
+	for name := range result {
+		if strings.HasPrefix(name, MetaPrefix) {
+			delete(result, name)
+		}
+	}
+	return result, true
+}
+
+// References reports whether any rule may depend on one of the given
+// labels, i.e. reads it as a source label or maps it with labelmap. Label
+// sets lacking only unreferenced labels relabel to the same outcome.
+func References(rules []*Rule, names []string) bool {
+	for _, rule := range rules {
+		for _, name := range names {
+			if rule.action == LabelMap && rule.regex.MatchString(name) {
+				return true
+			}
+			for _, source := range rule.sourceLabels {
+				if source == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// apply runs a single rule on the label set in place and reports whether
+// the label set is kept
+func (r *Rule) apply(labels map[string]string) bool {
+	values := make([]string, len(r.sourceLabels))
+	for i, name := range r.sourceLabels {
+		values[i] = labels[name]
+	}
+	value := strings.Join(values, r.separator)
+
+	switch r.action {
+	case Keep:
+		return r.regex.MatchString(value)
+	case Drop:
+		return !r.regex.MatchString(value)
+	case Replace:
+		indexes := r.regex.FindStringSubmatchIndex(value)
+		if indexes == nil {
+			break
+		}
+		target := string(r.regex.ExpandString(nil, r.targetLabel, value, indexes))
+		if !labelName.MatchString(target) {
+			break
+		}
+		if result := string(r.regex.ExpandString(nil, r.replacement, value, indexes)); result != "" {
+			labels[target] = result
+		} else {
+			delete(labels, target)
+		}
+	case HashMod:
+		sum := md5.Sum([]byte(value))
+		labels[r.targetLabel] = fmt.Sprint(binary.BigEndian.Uint64(sum[8:]) % r.modulus)
+	case LabelMap:
+		mapped := make(map[string]string)
+		for name, v := range labels {
+			if r.regex.MatchString(name) {
+				mapped[r.regex.ReplaceAllString(name, r.replacement)] = v
+			}
+		}
+		for name, v := range mapped {
+			if labelName.MatchString(name) {
+				labels[name] = v
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+package relabel
+
+import (
+	"testing"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs []Config
+		input   map[string]string
+		want    map[string]string
+		dropped bool
+	}{
+		{
+			name: "replace from path",
+			configs: []Config{{
+				SourceLabels: []string{"__path__"},
+				Regex:        "/system.slice/(.+)\\.service",
+				TargetLabel:  "service",
+			}},
+			input: map[string]string{"cgroup": "system.slice.sshd.service", "__path__": "/system.slice/sshd.service"},
+			want:  map[string]string{"cgroup": "system.slice.sshd.service", "service": "sshd"},
+		},
+		{
+			name: "replace without match",
+			configs: []Config{{
+				SourceLabels: []string{"__path__"},
+				Regex:        "/user.slice/(.+)",
+				TargetLabel:  "user",
+			}},
+			input: map[string]string{"cgroup": "a"},
+			want:  map[string]string{"cgroup": "a"},
+		},
+		{
+			name: "empty replacement deletes",
+			configs: []Config{{
+				SourceLabels: []string{"image"},
+				TargetLabel:  "image",
+				Replacement:  stringPtr(""),
+			}},
+			input: map[string]string{"cgroup": "a", "image": "nginx"},
+			want:  map[string]string{"cgroup": "a"},
+		},
+		{
+			name: "joined source labels",
+			configs: []Config{{
+				SourceLabels: []string{"namespace", "pod_name"},
+				Separator:    "/",
+				TargetLabel:  "pod",
+			}},
+			input: map[string]string{"namespace": "shop", "pod_name": "web"},
+			want:  map[string]string{"namespace": "shop", "pod_name": "web", "pod": "shop/web"},
+		},
+		{
+			name:    "keep",
+			configs: []Config{{SourceLabels: []string{"__path__"}, Regex: "/kubepods.*", Action: "keep"}},
+			input:   map[string]string{"__path__": "/system.slice"},
+			dropped: true,
+		},
+		{
+			name:    "drop",
+			configs: []Config{{SourceLabels: []string{"cgroup"}, Regex: ".*\\.mount", Action: "drop"}},
+			input:   map[string]string{"cgroup": "system.slice.boot.mount"},
+			dropped: true,
+		},
+		{
+			name:    "labelmap",
+			configs: []Config{{Regex: "pod_label_(.+)", Action: "labelmap"}},
+			input:   map[string]string{"pod_label_team": "checkout"},
+			want:    map[string]string{"pod_label_team": "checkout", "team": "checkout"},
+		},
+		{
+			name: "hashmod",
+			configs: []Config{{
+				SourceLabels: []string{"cgroup"},
+				Modulus:      4,
+				TargetLabel:  "shard",
+				Action:       "hashmod",
+			}},
+			input: map[string]string{"cgroup": "system.slice"},
+			want:  map[string]string{"cgroup": "system.slice", "shard": "0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := Compile(tt.configs)
+			if err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			got, keep := Process(tt.input, rules)
+			if keep == tt.dropped {
+				t.Fatalf("Expected dropped=%v, got labels %v", tt.dropped, got)
+			}
+			if tt.dropped {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("Expected labels %v, got %v", tt.want, got)
+			}
+			for name, value := range tt.want {
+				if got[name] != value {
+					t.Errorf("Expected %s=%q, got %q", name, value, got[name])
+				}
+			}
+		})
+	}
+}
+
+func TestCompile_Invalid(t *testing.T) {
+	tests := map[string]Config{
+		"unknown action":     {Action: "rewrite", TargetLabel: "a"},
+		"invalid regex":      {Regex: "(", TargetLabel: "a"},
+		"missing target":     {SourceLabels: []string{"a"}},
+		"hashmod modulus":    {SourceLabels: []string{"a"}, TargetLabel: "b", Action: "hashmod"},
+		"keep without input": {Action: "keep"},
+	}
+
+	for name, cfg := range tests {
+		if _, err := Compile([]Config{cfg}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package remotewrite
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// label is a label of a time series
+type label struct {
+	name, value string
+}
+
+// timeSeries is a time series with a single sample
+type timeSeries struct {
+	labels    []label
+	value     float64
+	timestamp int64 // milliseconds since the epoch
+}
+
+// toTimeSeries flattens gathered metric families into time series as
+// Prometheus would store them after a scrape at timestamp: histograms and
+// summaries become their _bucket, _sum and _count series. External labels
+// are added to series that do not have them.
+func toTimeSeries(families []*dto.MetricFamily, externalLabels map[string]string, timestamp int64) []timeSeries {
+	var series []timeSeries
+	for _, family := range families {
+		name := family.GetName()
+		for _, m := range family.GetMetric() {
+			ts := timestamp
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			add := func(suffix string, value float64, extra ...label) {
+				series = append(series, timeSeries{
+					labels:    seriesLabels(name+suffix, m.GetLabel(), externalLabels, extra...),
+					value:     value,
+					timestamp: ts,
+				})
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				summary := m.GetSummary()
+				for _, q := range summary.GetQuantile() {
+					add("", q.GetValue(), label{"quantile", formatFloat(q.GetQuantile())})
+				}
+				add("_sum", summary.GetSampleSum())
+				add("_count", float64(summary.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				histogram := m.GetHistogram()
+				infSeen := false
+				for _, b := range histogram.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						infSeen = true
+					}
+					add("_bucket", float64(b.GetCumulativeCount()), label{"le", formatFloat(b.GetUpperBound())})
+				}
+				if !infSeen {
+					add("_bucket", float64(histogram.GetSampleCount()), label{"le", "+Inf"})
+				}
+				add("_sum", histogram.GetSampleSum())
+				add("_count", float64(histogram.GetSampleCount()))
+			}
+		}
+	}
+	return series
+}
+
+// seriesLabels returns the sorted labels of a series. Empty labels are left
+// out, as Prometheus does not store them.
+func seriesLabels(name string, pairs []*dto.LabelPair, externalLabels map[string]string, extra ...label) []label {
+	labels := make([]label, 0, len(pairs)+len(extra)+len(externalLabels)+1)
+	labels = append(labels, label{"__name__", name})
+	seen := map[string]bool{"__name__": true}
+	for _, pair := range pairs {
+		if pair.GetValue() != "" {
+			labels = append(labels, label{pair.GetName(), pair.GetValue()})
+		}
+		seen[pair.GetName()] = true
+	}
+	for _, l := range extra {
+		labels = append(labels, l)
+		seen[l.name] = true
+	}
+	for name, value := range externalLabels {
+		if !seen[name] && value != "" {
+			labels = append(labels, label{name, value})
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+	return labels
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeWriteRequest encodes series as a remote_write WriteRequest:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []timeSeries) []byte {
+	var request, ts, field []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			field = field[:0]
+			field = protowire.AppendTag(field, 1, protowire.BytesType)
+			field = protowire.AppendString(field, l.name)
+			field = protowire.AppendTag(field, 2, protowire.BytesType)
+			field = protowire.AppendString(field, l.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, field)
+		}
+
+		field = field[:0]
+		field = protowire.AppendTag(field, 1, protowire.Fixed64Type)
+		field = protowire.AppendFixed64(field, math.Float64bits(s.value))
+		field = protowire.AppendTag(field, 2, protowire.VarintType)
+		field = protowire.AppendVarint(field, uint64(s.timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, field)
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, ts)
+	}
+	return request
+}
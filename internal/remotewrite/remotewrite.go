@@ -0,0 +1,223 @@
+// Package remotewrite pushes the metrics of the exporter to a Prometheus
+// remote_write endpoint, for nodes that Prometheus cannot scrape.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/version"
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+)
+
+// Pusher periodically gathers the metrics and sends them with the
+// remote_write 1.0 protocol. Batches wait in a bounded in-memory queue while
+// the endpoint is unavailable; there is no write-ahead log, so queued
+// samples are lost on restart and the oldest batch is dropped when the
+// queue is full.
+type Pusher struct {
+	config   config.RemoteWriteConfig
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	logger   *logrus.Logger
+	queue    chan []timeSeries
+
+	registry       *prometheus.Registry
+	samplesSent    prometheus.Counter
+	samplesFailed  prometheus.Counter
+	samplesDropped prometheus.Counter
+	retries        prometheus.Counter
+	lastSend       prometheus.Gauge
+}
+
+// NewPusher creates a pusher of the metrics of gatherer. Its own metrics are
+// pushed along with them.
+func NewPusher(cfg config.RemoteWriteConfig, gatherer prometheus.Gatherer, logger *logrus.Logger) *Pusher {
+	p := &Pusher{
+		config: cfg,
+		client: &http.Client{},
+		logger: logger,
+		queue:  make(chan []timeSeries, cfg.Queue.Capacity),
+
+		registry: prometheus.NewRegistry(),
+		samplesSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheus_cgroup_v2_exporter_remote_write_sent_samples_total",
+			Help: "Total number of samples sent to the remote_write endpoint",
+		}),
+		samplesFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheus_cgroup_v2_exporter_remote_write_failed_samples_total",
+			Help: "Total number of samples rejected by the remote_write endpoint or failing after all retries",
+		}),
+		samplesDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheus_cgroup_v2_exporter_remote_write_dropped_samples_total",
+			Help: "Total number of samples dropped because the send queue was full",
+		}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheus_cgroup_v2_exporter_remote_write_retries_total",
+			Help: "Total number of retried remote_write requests",
+		}),
+		lastSend: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "prometheus_cgroup_v2_exporter_remote_write_last_send_timestamp_seconds",
+			Help: "Unix timestamp of the last successful remote_write request",
+		}),
+	}
+	p.registry.MustRegister(p.samplesSent, p.samplesFailed, p.samplesDropped, p.retries, p.lastSend)
+	p.gatherer = prometheus.Gatherers{gatherer, p.registry}
+	return p
+}
+
+// Start pushes the metrics every interval until ctx is cancelled
+func (p *Pusher) Start(ctx context.Context) error {
+	p.logger.WithFields(logrus.Fields{
+		"url":      p.config.URL,
+		"interval": p.config.Interval,
+	}).Info("Starting remote_write push mode")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.sendLoop(ctx)
+	}()
+
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+	for {
+		p.push()
+		select {
+		case <-ctx.Done():
+			<-done
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// push gathers the metrics and queues them in batches
+func (p *Pusher) push() {
+	families, err := p.gatherer.Gather()
+	if err != nil {
+		// Like promhttp with ContinueOnError, push what could be gathered
+		p.logger.WithError(err).Warn("Failed to gather some metrics for remote_write")
+	}
+
+	series := toTimeSeries(families, p.config.ExternalLabels, time.Now().UnixMilli())
+	for len(series) > 0 {
+		n := min(len(series), p.config.Queue.MaxSamplesPerSend)
+		p.enqueue(series[:n])
+		series = series[n:]
+	}
+}
+
+// enqueue adds a batch to the queue, dropping the oldest batch when full
+func (p *Pusher) enqueue(batch []timeSeries) {
+	for {
+		select {
+		case p.queue <- batch:
+			return
+		default:
+		}
+
+		select {
+		case oldest := <-p.queue:
+			p.samplesDropped.Add(float64(len(oldest)))
+			p.logger.WithField("samples", len(oldest)).Warn("remote_write queue is full, dropping the oldest batch")
+		default:
+		}
+	}
+}
+
+// sendLoop sends the queued batches one at a time
+func (p *Pusher) sendLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case batch := <-p.queue:
+			p.sendBatch(ctx, batch)
+		}
+	}
+}
+
+// sendBatch sends a batch, retrying recoverable errors with exponential
+// backoff
+func (p *Pusher) sendBatch(ctx context.Context, batch []timeSeries) {
+	body := snappy.Encode(nil, encodeWriteRequest(batch))
+	backoff := p.config.Queue.MinBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := p.send(ctx, body)
+		if err == nil {
+			p.samplesSent.Add(float64(len(batch)))
+			p.lastSend.SetToCurrentTime()
+			return
+		}
+
+		var recoverable recoverableError
+		if !errors.As(err, &recoverable) || attempt >= p.config.Queue.MaxRetries || ctx.Err() != nil {
+			p.samplesFailed.Add(float64(len(batch)))
+			p.logger.WithError(err).WithField("samples", len(batch)).Error("Failed to send samples to remote_write endpoint")
+			return
+		}
+
+		p.retries.Inc()
+		p.logger.WithError(err).WithField("backoff", backoff).Debug("Retrying remote_write request")
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, p.config.Queue.MaxBackoff)
+	}
+}
+
+// recoverableError is an error worth retrying: a network error, a 5xx or a
+// 429 status
+type recoverableError struct {
+	error
+}
+
+// send makes a single remote_write request
+func (p *Pusher) send(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "prometheus-cgroup-v2-exporter/"+version.Version)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if auth := p.config.BasicAuth; auth.Username != "" {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	} else if p.config.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.BearerToken)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return recoverableError{err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	err = fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return recoverableError{err}
+	}
+	return err
+}
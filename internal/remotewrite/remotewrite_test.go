@@ -0,0 +1,220 @@
+package remotewrite
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+)
+
+// decodeWriteRequest decodes a snappy-compressed WriteRequest into series
+// keyed by their labels in the text format, e.g. `up{job="a"}`
+func decodeWriteRequest(t *testing.T, body []byte) map[string]timeSeries {
+	t.Helper()
+
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatalf("Failed to decode snappy: %v", err)
+	}
+
+	// fields returns the fields of a message by number
+	fields := func(msg []byte) map[protowire.Number][][]byte {
+		result := make(map[protowire.Number][][]byte)
+		for len(msg) > 0 {
+			num, typ, n := protowire.ConsumeTag(msg)
+			if n < 0 {
+				t.Fatalf("Invalid tag: %v", protowire.ParseError(n))
+			}
+			msg = msg[n:]
+			var value []byte
+			switch typ {
+			case protowire.BytesType:
+				value, n = protowire.ConsumeBytes(msg)
+			default:
+				n = protowire.ConsumeFieldValue(num, typ, msg)
+				value = msg[:n]
+			}
+			if n < 0 {
+				t.Fatalf("Invalid field %d: %v", num, protowire.ParseError(n))
+			}
+			result[num] = append(result[num], value)
+			msg = msg[n:]
+		}
+		return result
+	}
+
+	series := make(map[string]timeSeries)
+	for _, ts := range fields(data)[1] {
+		var s timeSeries
+		tsFields := fields(ts)
+		for _, l := range tsFields[1] {
+			labelFields := fields(l)
+			s.labels = append(s.labels, label{string(labelFields[1][0]), string(labelFields[2][0])})
+		}
+		sample := fields(tsFields[2][0])
+		bits, _ := protowire.ConsumeFixed64(sample[1][0])
+		timestamp, _ := protowire.ConsumeVarint(sample[2][0])
+		s.value, s.timestamp = math.Float64frombits(bits), int64(timestamp)
+
+		var key strings.Builder
+		for i, l := range s.labels {
+			switch {
+			case l.name == "__name__":
+				key.WriteString(l.value + "{")
+				continue
+			case i > 1:
+				key.WriteString(",")
+			}
+			key.WriteString(l.name + `="` + l.value + `"`)
+		}
+		key.WriteString("}")
+		series[key.String()] = s
+	}
+	return series
+}
+
+func newTestConfig(url string) config.RemoteWriteConfig {
+	return config.RemoteWriteConfig{
+		URL:            url,
+		Interval:       time.Hour,
+		Timeout:        5 * time.Second,
+		ExternalLabels: map[string]string{"node": "edge-1", "job": "edge"},
+		BearerToken:    "secret",
+		Queue: config.QueueConfig{
+			Capacity:          2,
+			MaxSamplesPerSend: 100,
+			MaxRetries:        2,
+			MinBackoff:        time.Millisecond,
+			MaxBackoff:        time.Millisecond,
+		},
+	}
+}
+
+func TestPusher(t *testing.T) {
+	received := make(chan map[string]timeSeries, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("Unexpected headers: %v", r.Header)
+		}
+		if r.Header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+			t.Errorf("Expected remote_write version 0.1.0, got %q", r.Header.Get("X-Prometheus-Remote-Write-Version"))
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer secret" {
+			t.Errorf("Expected the bearer token, got %q", auth)
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- decodeWriteRequest(t, body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_memory_bytes", Help: "Test gauge."}, []string{"cgroup", "job"})
+	gauge.WithLabelValues("system.slice", "cgroups").Set(4096)
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_duration_seconds", Help: "Test histogram.", Buckets: []float64{0.1, 1}})
+	histogram.Observe(0.5)
+	registry.MustRegister(gauge, histogram)
+
+	p := NewPusher(newTestConfig(server.URL), registry, logrus.New())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- p.Start(ctx) }()
+
+	var series map[string]timeSeries
+	select {
+	case series = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a remote_write request")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Start failed: %v", err)
+	}
+
+	want := map[string]float64{
+		`test_memory_bytes{cgroup="system.slice",job="cgroups",node="edge-1"}`: 4096,
+		`test_duration_seconds_bucket{job="edge",le="0.1",node="edge-1"}`:      0,
+		`test_duration_seconds_bucket{job="edge",le="1",node="edge-1"}`:        1,
+		`test_duration_seconds_bucket{job="edge",le="+Inf",node="edge-1"}`:     1,
+		`test_duration_seconds_sum{job="edge",node="edge-1"}`:                  0.5,
+		`test_duration_seconds_count{job="edge",node="edge-1"}`:                1,
+	}
+	for key, value := range want {
+		s, ok := series[key]
+		if !ok {
+			t.Errorf("Expected series %s, got %v", key, series)
+			continue
+		}
+		if s.value != value {
+			t.Errorf("%s: expected %v, got %v", key, value, s.value)
+		}
+		if time.Since(time.UnixMilli(s.timestamp)) > time.Minute {
+			t.Errorf("%s: expected a current timestamp, got %d", key, s.timestamp)
+		}
+	}
+	if _, ok := series[`prometheus_cgroup_v2_exporter_remote_write_sent_samples_total{job="edge",node="edge-1"}`]; !ok {
+		t.Error("Expected the remote_write metrics to be pushed")
+	}
+}
+
+func TestPusher_Retries(t *testing.T) {
+	var requests atomic.Int32
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			http.Error(w, "overloaded", status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	batch := []timeSeries{{labels: []label{{"__name__", "up"}}, value: 1}}
+	p := NewPusher(newTestConfig(server.URL), prometheus.NewRegistry(), logrus.New())
+
+	p.sendBatch(context.Background(), batch)
+	if got := requests.Load(); got != 3 {
+		t.Errorf("Expected 3 requests, got %d", got)
+	}
+	if sent, retries := testutil.ToFloat64(p.samplesSent), testutil.ToFloat64(p.retries); sent != 1 || retries != 2 {
+		t.Errorf("Expected 1 sent sample after 2 retries, got %v sent and %v retries", sent, retries)
+	}
+
+	// Client errors are not retried
+	requests.Store(0)
+	status = http.StatusBadRequest
+	p.sendBatch(context.Background(), batch)
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Expected a single request for a 400 status, got %d", got)
+	}
+	if failed := testutil.ToFloat64(p.samplesFailed); failed != 1 {
+		t.Errorf("Expected 1 failed sample, got %v", failed)
+	}
+}
+
+func TestPusher_QueueFull(t *testing.T) {
+	p := NewPusher(newTestConfig("http://127.0.0.1:0"), prometheus.NewRegistry(), logrus.New())
+
+	for i := 0; i < 3; i++ {
+		p.enqueue([]timeSeries{{value: float64(i)}, {value: float64(i)}})
+	}
+	if dropped := testutil.ToFloat64(p.samplesDropped); dropped != 2 {
+		t.Errorf("Expected the 2 samples of the oldest batch to be dropped, got %v", dropped)
+	}
+	if oldest := <-p.queue; oldest[0].value != 1 {
+		t.Errorf("Expected the second batch to be the oldest queued, got %v", oldest[0].value)
+	}
+}
@@ -0,0 +1,94 @@
+// Package textfile writes the metrics of the exporter to a file for the
+// textfile collector of node_exporter, for hosts that allow a single
+// listening exporter.
+package textfile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+)
+
+// Writer periodically gathers the metrics and writes them in the text format.
+// Each write goes to a temporary file in the same directory that is renamed
+// over the target, so node_exporter never reads a partial file.
+type Writer struct {
+	config   config.OutputConfig
+	gatherer prometheus.Gatherer
+	logger   *logrus.Logger
+}
+
+// NewWriter creates a writer of the metrics of gatherer
+func NewWriter(cfg config.OutputConfig, gatherer prometheus.Gatherer, logger *logrus.Logger) *Writer {
+	return &Writer{
+		config:   cfg,
+		gatherer: gatherer,
+		logger:   logger,
+	}
+}
+
+// Start writes the metrics every interval until ctx is cancelled
+func (w *Writer) Start(ctx context.Context) error {
+	w.logger.WithFields(logrus.Fields{
+		"path":     w.config.Textfile,
+		"interval": w.config.Interval,
+	}).Info("Starting textfile output")
+
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+	for {
+		if err := w.Write(); err != nil {
+			w.logger.WithError(err).Error("Failed to write textfile")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Write gathers the metrics and atomically replaces the textfile
+func (w *Writer) Write() error {
+	families, err := w.gatherer.Gather()
+	if err != nil {
+		// Like promhttp with ContinueOnError, write what could be gathered
+		w.logger.WithError(err).Warn("Failed to gather some metrics for the textfile")
+	}
+
+	dir, base := filepath.Split(w.config.Textfile)
+	// node_exporter only reads *.prom, so the temporary file is never read
+	tmp, err := os.CreateTemp(dir, "."+base+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(tmp, family); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to write metrics: %w", err)
+		}
+	}
+	// CreateTemp uses mode 0600, but node_exporter usually runs as another
+	// user
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), w.config.Textfile); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", w.config.Textfile, err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package textfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+)
+
+func TestWriter_Write(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cgroups.prom")
+
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_memory_bytes", Help: "Test gauge."}, []string{"cgroup"})
+	gauge.WithLabelValues("system.slice").Set(4096)
+	registry.MustRegister(gauge)
+
+	w := NewWriter(config.OutputConfig{Textfile: path, Interval: time.Minute}, registry, logrus.New())
+	if err := w.Write(); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	gauge.WithLabelValues("system.slice").Set(8192)
+	if err := w.Write(); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read textfile: %v", err)
+	}
+	for _, want := range []string{
+		"# TYPE test_memory_bytes gauge\n",
+		`test_memory_bytes{cgroup="system.slice"} 8192` + "\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected %q in textfile, got:\n%s", want, data)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o644 {
+		t.Errorf("Expected mode 0644, got %v", mode)
+	}
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the textfile, got %d entries", len(entries))
+	}
+}
+
+func TestWriter_WriteMissingDirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "cgroups.prom")
+	w := NewWriter(config.OutputConfig{Textfile: path, Interval: time.Minute}, prometheus.NewRegistry(), logrus.New())
+	if err := w.Write(); err == nil {
+		t.Error("Expected an error for a missing directory")
+	}
+}
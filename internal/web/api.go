@@ -0,0 +1,432 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/collector"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/fsys"
+)
+
+// APIPrefix is the path prefix of the JSON API
+const APIPrefix = "/api/v1/"
+
+// APIHandler serves structured per-cgroup data as JSON:
+//
+//	GET /api/v1/cgroups[/]        list cgroups, filtered by the prefix,
+//	                              controller and label=<name>=<value> parameters
+//	GET /api/v1/cgroups/<path>    one cgroup with limits, usage and pressure
+//	GET /api/v1/pids/<pid>        the cgroup of a process and the limits of
+//	                              its ancestors
+//
+// The cgroups are those of the latest scan of the collectors, so the API
+// shows the same cgroups and labels as /metrics. Tenants only see their
+// own cgroups.
+type APIHandler struct {
+	sources collector.Sources
+	logger  *logrus.Logger
+}
+
+// NewAPIHandler creates an API handler. sources.Scanner must be the scanner
+// shared with the collectors.
+func NewAPIHandler(sources collector.Sources, logger *logrus.Logger) *APIHandler {
+	return &APIHandler{
+		sources: sources,
+		logger:  logger,
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+		return
+	}
+
+	route, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, APIPrefix), "/")
+	switch {
+	case route == "cgroups" && strings.Trim(rest, "/") == "":
+		h.listCgroups(w, r)
+	case route == "cgroups":
+		h.getCgroup(w, r, "/"+strings.Trim(rest, "/"))
+	case route == "pids" && rest != "" && !strings.Contains(rest, "/"):
+		h.getPID(w, r, rest)
+	default:
+		writeError(w, http.StatusNotFound, "unknown API endpoint %s", r.URL.Path)
+	}
+}
+
+// cgroupSummary is the list view of a cgroup
+type cgroupSummary struct {
+	Path        string            `json:"path"`
+	ID          uint64            `json:"id"`
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels"`
+	Controllers []string          `json:"controllers"`
+	Created     *time.Time        `json:"created,omitempty"`
+}
+
+// cgroupList is the response of /api/v1/cgroups
+type cgroupList struct {
+	ScannedAt time.Time       `json:"scanned_at"`
+	Cgroups   []cgroupSummary `json:"cgroups"`
+}
+
+// cgroupDetail is the response of /api/v1/cgroups/<path>
+type cgroupDetail struct {
+	cgroupSummary
+	ScannedAt time.Time                   `json:"scanned_at"`
+	Limits    cgroupLimits                `json:"limits"`
+	Usage     cgroupUsage                 `json:"usage"`
+	Pressure  map[string]*cgroup.Pressure `json:"pressure,omitempty"`
+}
+
+// pidCgroup is the response of /api/v1/pids/<pid>
+type pidCgroup struct {
+	PID    int           `json:"pid"`
+	Cgroup *cgroupDetail `json:"cgroup"`
+	// Ancestors are the parents of the cgroup up to the root, or up to the
+	// topmost cgroup visible to the tenant
+	Ancestors []cgroupAncestor `json:"ancestors"`
+}
+
+// cgroupAncestor is a parent cgroup with the limits it imposes on its
+// descendants
+type cgroupAncestor struct {
+	Path   string       `json:"path"`
+	Limits cgroupLimits `json:"limits"`
+}
+
+// cgroupLimits are the resource limits of a cgroup. Limits of disabled
+// controllers are left out.
+type cgroupLimits struct {
+	MemoryMax     *limit  `json:"memory_max,omitempty"`
+	MemoryHigh    *limit  `json:"memory_high,omitempty"`
+	MemoryLow     *limit  `json:"memory_low,omitempty"`
+	MemoryMin     *limit  `json:"memory_min,omitempty"`
+	MemorySwapMax *limit  `json:"memory_swap_max,omitempty"`
+	CPUMax        *cpuMax `json:"cpu_max,omitempty"`
+	CPUWeight     *uint64 `json:"cpu_weight,omitempty"`
+	PIDsMax       *limit  `json:"pids_max,omitempty"`
+}
+
+// cpuMax is the CPU bandwidth limit: quota microseconds per period
+type cpuMax struct {
+	Quota  limit  `json:"quota_usec"`
+	Period uint64 `json:"period_usec"`
+}
+
+// cgroupUsage is the current resource usage of a cgroup
+type cgroupUsage struct {
+	CPUSeconds          *float64                     `json:"cpu_seconds,omitempty"`
+	CPUUserSeconds      *float64                     `json:"cpu_user_seconds,omitempty"`
+	CPUSystemSeconds    *float64                     `json:"cpu_system_seconds,omitempty"`
+	CPUThrottledSeconds *float64                     `json:"cpu_throttled_seconds,omitempty"`
+	MemoryBytes         *uint64                      `json:"memory_bytes,omitempty"`
+	MemorySwapBytes     *uint64                      `json:"memory_swap_bytes,omitempty"`
+	PIDs                *uint64                      `json:"pids,omitempty"`
+	IO                  map[string]map[string]uint64 `json:"io,omitempty"`
+}
+
+// limit is a limit value, encoded as "max" when unlimited
+type limit uint64
+
+// MarshalJSON implements json.Marshaler
+func (l limit) MarshalJSON() ([]byte, error) {
+	if l == math.MaxUint64 {
+		return []byte(`"max"`), nil
+	}
+	return []byte(strconv.FormatUint(uint64(l), 10)), nil
+}
+
+func (h *APIHandler) listCgroups(w http.ResponseWriter, r *http.Request) {
+	cgroups, scannedAt, err := h.snapshot(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	labels := make(map[string]string)
+	for _, matcher := range query["label"] {
+		name, value, ok := strings.Cut(matcher, "=")
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid label filter %q, expected <name>=<value>", matcher)
+			return
+		}
+		labels[name] = value
+	}
+
+	list := cgroupList{ScannedAt: scannedAt, Cgroups: []cgroupSummary{}}
+	for _, cg := range cgroups {
+		if !withinPrefix(cg.Path, prefix) || !hasControllers(cg, query["controller"]) || !hasLabels(cg, labels) {
+			continue
+		}
+		list.Cgroups = append(list.Cgroups, summarize(cg))
+	}
+	sort.Slice(list.Cgroups, func(i, j int) bool { return list.Cgroups[i].Path < list.Cgroups[j].Path })
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (h *APIHandler) getCgroup(w http.ResponseWriter, r *http.Request, cgroupPath string) {
+	cgroups, scannedAt, err := h.snapshot(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	for _, cg := range cgroups {
+		if cg.Path == cgroupPath {
+			writeJSON(w, http.StatusOK, h.detail(cg, scannedAt))
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "cgroup %s not found", cgroupPath)
+}
+
+func (h *APIHandler) getPID(w http.ResponseWriter, r *http.Request, rawPID string) {
+	pid, err := strconv.Atoi(rawPID)
+	if err != nil || pid <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid pid %q", rawPID)
+		return
+	}
+	// Tenants cannot tell the processes of others from missing ones
+	tenant := TenantFromContext(r.Context())
+	cgroupPath, err := cgroup.ReadProcCgroup(h.sources.Proc, pid)
+	if errors.Is(err, cgroup.ErrOutsideNamespace) && tenant == nil {
+		writeError(w, http.StatusNotFound, "%v", err)
+		return
+	}
+	if err != nil {
+		h.logger.WithError(err).WithField("pid", pid).Debug("Failed to resolve the cgroup of a process")
+		writeError(w, http.StatusNotFound, "process %d not found", pid)
+		return
+	}
+
+	cgroups, scannedAt, err := h.snapshot(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	var found *cgroup.CgroupInfo
+	for _, cg := range cgroups {
+		if cg.Path == cgroupPath {
+			found = cg
+			break
+		}
+	}
+	if found == nil && tenant != nil {
+		writeError(w, http.StatusNotFound, "process %d not found", pid)
+		return
+	}
+	if found == nil {
+		writeError(w, http.StatusNotFound, "cgroup of process %d not found", pid)
+		return
+	}
+
+	result := pidCgroup{PID: pid, Cgroup: h.detail(found, scannedAt), Ancestors: []cgroupAncestor{}}
+	for parent := found.Path; parent != "/"; {
+		parent = path.Dir(parent)
+		if tenant != nil && !tenant.Allowed(parent) {
+			break
+		}
+		result.Ancestors = append(result.Ancestors, cgroupAncestor{
+			Path:   parent,
+			Limits: readLimits(h.sources.Cgroup, parent),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// snapshot returns the cgroups of the latest scan visible to the requester,
+// scanning first if the collectors have not yet
+func (h *APIHandler) snapshot(ctx context.Context) ([]*cgroup.CgroupInfo, time.Time, error) {
+	scanner := h.sources.Scanner
+	cgroups, scannedAt := scanner.Snapshot()
+	if scannedAt.IsZero() {
+		if _, err := scanner.Scan(ctx); err != nil {
+			h.logger.WithError(err).Error("Failed to scan cgroups")
+			return nil, time.Time{}, err
+		}
+		cgroups, scannedAt = scanner.Snapshot()
+	}
+
+	tenant := TenantFromContext(ctx)
+	if tenant == nil {
+		return cgroups, scannedAt, nil
+	}
+	var visible []*cgroup.CgroupInfo
+	for _, cg := range cgroups {
+		if tenant.Allowed(cg.Path) {
+			visible = append(visible, cg)
+		}
+	}
+	return visible, scannedAt, nil
+}
+
+// detail reads the limits, usage and pressure of a cgroup
+func (h *APIHandler) detail(cg *cgroup.CgroupInfo, scannedAt time.Time) *cgroupDetail {
+	return &cgroupDetail{
+		cgroupSummary: summarize(cg),
+		ScannedAt:     scannedAt,
+		Limits:        readLimits(h.sources.Cgroup, cg.Path),
+		Usage:         readUsage(h.sources.Cgroup, cg.Path),
+		Pressure:      readPressure(h.sources.Cgroup, cg.Path),
+	}
+}
+
+func summarize(cg *cgroup.CgroupInfo) cgroupSummary {
+	summary := cgroupSummary{
+		Path:        cg.Path,
+		ID:          cg.ID,
+		Name:        cg.Name,
+		Labels:      cg.Labels,
+		Controllers: cg.Controllers,
+	}
+	if !cg.Created.IsZero() {
+		created := cg.Created
+		summary.Created = &created
+	}
+	return summary
+}
+
+func readLimits(cgroupFS fsys.FS, cgroupPath string) cgroupLimits {
+	var limits cgroupLimits
+	for file, target := range map[string]**limit{
+		"memory.max":      &limits.MemoryMax,
+		"memory.high":     &limits.MemoryHigh,
+		"memory.low":      &limits.MemoryLow,
+		"memory.min":      &limits.MemoryMin,
+		"memory.swap.max": &limits.MemorySwapMax,
+		"pids.max":        &limits.PIDsMax,
+	} {
+		if value, err := cgroup.ReadUint(cgroupFS, cgroupPath, file); err == nil {
+			l := limit(value)
+			*target = &l
+		}
+	}
+	if weight, err := cgroup.ReadUint(cgroupFS, cgroupPath, "cpu.weight"); err == nil {
+		limits.CPUWeight = &weight
+	}
+	if data, err := cgroupFS.ReadFile(fsys.Join(cgroupPath, "cpu.max")); err == nil {
+		limits.CPUMax = parseCPUMax(string(data))
+	}
+	return limits
+}
+
+// parseCPUMax parses cpu.max, e.g. "max 100000" or "50000 100000"
+func parseCPUMax(data string) *cpuMax {
+	fields := strings.Fields(data)
+	if len(fields) != 2 {
+		return nil
+	}
+	period, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return nil
+	}
+	quota := uint64(math.MaxUint64)
+	if fields[0] != "max" {
+		if quota, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+			return nil
+		}
+	}
+	return &cpuMax{Quota: limit(quota), Period: period}
+}
+
+func readUsage(cgroupFS fsys.FS, cgroupPath string) cgroupUsage {
+	var usage cgroupUsage
+	if stat, err := cgroup.ReadFlatKeyed(cgroupFS, cgroupPath, "cpu.stat"); err == nil {
+		seconds := func(key string) *float64 {
+			value, ok := stat[key]
+			if !ok {
+				return nil
+			}
+			s := float64(value) / 1e6
+			return &s
+		}
+		usage.CPUSeconds = seconds("usage_usec")
+		usage.CPUUserSeconds = seconds("user_usec")
+		usage.CPUSystemSeconds = seconds("system_usec")
+		usage.CPUThrottledSeconds = seconds("throttled_usec")
+	}
+	for file, target := range map[string]**uint64{
+		"memory.current":      &usage.MemoryBytes,
+		"memory.swap.current": &usage.MemorySwapBytes,
+		"pids.current":        &usage.PIDs,
+	} {
+		if value, err := cgroup.ReadUint(cgroupFS, cgroupPath, file); err == nil {
+			*target = &value
+		}
+	}
+	if stat, err := cgroup.ReadNestedKeyed(cgroupFS, cgroupPath, "io.stat"); err == nil && len(stat) > 0 {
+		usage.IO = stat
+	}
+	return usage
+}
+
+func readPressure(cgroupFS fsys.FS, cgroupPath string) map[string]*cgroup.Pressure {
+	pressure := make(map[string]*cgroup.Pressure)
+	for _, resource := range []string{"cpu", "memory", "io"} {
+		if p, err := cgroup.ReadPressure(cgroupFS, cgroupPath, resource+".pressure"); err == nil {
+			pressure[resource] = p
+		}
+	}
+	return pressure
+}
+
+// withinPrefix reports whether a cgroup lies in the subtree at prefix
+func withinPrefix(cgroupPath, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || cgroupPath == prefix || strings.HasPrefix(cgroupPath, prefix+"/")
+}
+
+func hasControllers(cg *cgroup.CgroupInfo, controllers []string) bool {
+	for _, want := range controllers {
+		found := false
+		for _, controller := range cg.Controllers {
+			if controller == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func hasLabels(cg *cgroup.CgroupInfo, labels map[string]string) bool {
+	for name, value := range labels {
+		if cg.Labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,246 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/collector"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/fsys"
+)
+
+func newTestAPIHandler(t *testing.T) *APIHandler {
+	t.Helper()
+
+	cgroupFS := fsys.NewMem()
+	files := map[string]string{
+		"cgroup.controllers":                          "cpu io memory pids\n",
+		"system.slice/cgroup.controllers":             "cpu memory pids\n",
+		"system.slice/memory.max":                     "1048576\n",
+		"system.slice/foo.service/cgroup.controllers": "memory pids\n",
+		"system.slice/foo.service/memory.current":     "4096\n",
+		"system.slice/foo.service/memory.max":         "max\n",
+		"system.slice/foo.service/memory.high":        "8192\n",
+		"system.slice/foo.service/pids.current":       "3\n",
+		"system.slice/foo.service/pids.max":           "100\n",
+		"system.slice/foo.service/cpu.max":            "50000 100000\n",
+		"system.slice/foo.service/cpu.stat":           "usage_usec 3000000\nuser_usec 2000000\nsystem_usec 1000000\n",
+		"system.slice/foo.service/memory.pressure":    "some avg10=1.50 avg60=0.00 avg300=0.00 total=250000\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n",
+		"system.slice/foo.service/io.stat":            "8:0 rbytes=512 wbytes=1024 rios=1 wios=2\n",
+		"user.slice/cgroup.controllers":               "cpu memory\n",
+	}
+	for name, data := range files {
+		if err := cgroupFS.WriteFile(name, []byte(data)); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	procFS := fsys.NewMem()
+	if err := procFS.WriteFile("42/cgroup", []byte("0::/system.slice/foo.service\n")); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := procFS.WriteFile("43/cgroup", []byte("1:name=systemd:/user.slice\n")); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := procFS.WriteFile("44/cgroup", []byte("0::/../system.slice/containerd.service\n")); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	cfg := &config.Config{Advanced: config.AdvancedConfig{MaxCgroups: 100}}
+	sources := collector.Sources{Cgroup: cgroupFS, Proc: procFS}
+	sources.Scanner = collector.NewScanner(cfg, sources, logrus.New())
+	return NewAPIHandler(sources, logrus.New())
+}
+
+// getJSON serves a request and decodes the JSON response into v
+func getJSON(t *testing.T, h http.Handler, r *http.Request, v interface{}) int {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected JSON, got content type %q", ct)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return rec.Code
+}
+
+func TestAPIHandler_List(t *testing.T) {
+	h := newTestAPIHandler(t)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"/", "/system.slice", "/system.slice/foo.service", "/user.slice"}},
+		{"?prefix=/system.slice", []string{"/system.slice", "/system.slice/foo.service"}},
+		{"?controller=pids", []string{"/", "/system.slice", "/system.slice/foo.service"}},
+		{"?controller=pids&controller=cpu", []string{"/", "/system.slice"}},
+		{"?label=cgroup=user.slice", []string{"/user.slice"}},
+	}
+
+	for _, tt := range tests {
+		var list cgroupList
+		code := getJSON(t, h, httptest.NewRequest(http.MethodGet, "/api/v1/cgroups"+tt.query, nil), &list)
+		if code != http.StatusOK {
+			t.Errorf("%q: expected status 200, got %d", tt.query, code)
+			continue
+		}
+		if list.ScannedAt.IsZero() {
+			t.Errorf("%q: expected the scan time", tt.query)
+		}
+		var got []string
+		for _, cg := range list.Cgroups {
+			got = append(got, cg.Path)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q: expected %v, got %v", tt.query, tt.want, got)
+				break
+			}
+		}
+	}
+
+	// A trailing slash lists the cgroups rather than getting the root
+	var list cgroupList
+	if code := getJSON(t, h, httptest.NewRequest(http.MethodGet, "/api/v1/cgroups/", nil), &list); code != http.StatusOK || len(list.Cgroups) != 4 {
+		t.Errorf("Expected the list of 4 cgroups for a trailing slash, got status %d: %v", code, list.Cgroups)
+	}
+
+	var apiErr map[string]string
+	if code := getJSON(t, h, httptest.NewRequest(http.MethodGet, "/api/v1/cgroups?label=cgroup", nil), &apiErr); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid label filter, got %d", code)
+	}
+}
+
+func TestAPIHandler_Get(t *testing.T) {
+	h := newTestAPIHandler(t)
+
+	var detail map[string]interface{}
+	code := getJSON(t, h, httptest.NewRequest(http.MethodGet, "/api/v1/cgroups/system.slice/foo.service", nil), &detail)
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+
+	if detail["path"] != "/system.slice/foo.service" {
+		t.Errorf("Expected path /system.slice/foo.service, got %v", detail["path"])
+	}
+	limits := detail["limits"].(map[string]interface{})
+	if limits["memory_max"] != "max" || limits["memory_high"] != 8192.0 || limits["pids_max"] != 100.0 {
+		t.Errorf("Unexpected memory and pids limits: %v", limits)
+	}
+	if cpuMax, _ := limits["cpu_max"].(map[string]interface{}); cpuMax["quota_usec"] != 50000.0 || cpuMax["period_usec"] != 100000.0 {
+		t.Errorf("Unexpected cpu.max: %v", limits["cpu_max"])
+	}
+	usage := detail["usage"].(map[string]interface{})
+	if usage["memory_bytes"] != 4096.0 || usage["pids"] != 3.0 || usage["cpu_seconds"] != 3.0 {
+		t.Errorf("Unexpected usage: %v", usage)
+	}
+	if _, ok := usage["io"].(map[string]interface{})["8:0"]; !ok {
+		t.Errorf("Expected io.stat of device 8:0, got %v", usage["io"])
+	}
+	pressure := detail["pressure"].(map[string]interface{})
+	if memory, _ := pressure["memory"].(map[string]interface{}); memory["some"].(map[string]interface{})["avg10"] != 1.5 {
+		t.Errorf("Unexpected memory pressure: %v", pressure["memory"])
+	}
+
+	var apiErr map[string]string
+	if code := getJSON(t, h, httptest.NewRequest(http.MethodGet, "/api/v1/cgroups/missing.slice", nil), &apiErr); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown cgroup, got %d", code)
+	}
+}
+
+func TestAPIHandler_Tenant(t *testing.T) {
+	h := newTestAPIHandler(t)
+	tenants, _, err := compileTenants([]TenantConfig{{
+		Name:         "users",
+		BearerTokens: []string{"token"},
+		Cgroups:      []string{"/user.slice"},
+	}})
+	if err != nil {
+		t.Fatalf("compileTenants failed: %v", err)
+	}
+	tenantRequest := func(target string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		return r.WithContext(withTenant(r.Context(), tenants[0]))
+	}
+
+	var list cgroupList
+	getJSON(t, h, tenantRequest("/api/v1/cgroups"), &list)
+	if len(list.Cgroups) != 1 || list.Cgroups[0].Path != "/user.slice" {
+		t.Errorf("Expected only the tenant's cgroups, got %v", list.Cgroups)
+	}
+
+	var apiErr map[string]string
+	if code := getJSON(t, h, tenantRequest("/api/v1/cgroups/system.slice"), &apiErr); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a cgroup of another tenant, got %d", code)
+	}
+	// Processes of other tenants are reported like missing ones
+	for _, pid := range []string{"42", "7", "44"} {
+		apiErr = nil
+		code := getJSON(t, h, tenantRequest("/api/v1/pids/"+pid), &apiErr)
+		if want := "process " + pid + " not found"; code != http.StatusNotFound || apiErr["error"] != want {
+			t.Errorf("PID %s: expected 404 %q, got %d %v", pid, want, code, apiErr)
+		}
+	}
+}
+
+func TestAPIHandler_PID(t *testing.T) {
+	h := newTestAPIHandler(t)
+
+	var result map[string]interface{}
+	code := getJSON(t, h, httptest.NewRequest(http.MethodGet, "/api/v1/pids/42", nil), &result)
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	cg := result["cgroup"].(map[string]interface{})
+	if cg["path"] != "/system.slice/foo.service" {
+		t.Errorf("Expected the cgroup /system.slice/foo.service, got %v", cg["path"])
+	}
+	if labels, _ := cg["labels"].(map[string]interface{}); labels["cgroup"] != "system.slice.foo.service" {
+		t.Errorf("Expected the cgroup labels, got %v", cg["labels"])
+	}
+	if usage, _ := cg["usage"].(map[string]interface{}); usage["pids"] != 3.0 {
+		t.Errorf("Expected the cgroup usage, got %v", cg["usage"])
+	}
+
+	ancestors := result["ancestors"].([]interface{})
+	if len(ancestors) != 2 {
+		t.Fatalf("Expected 2 ancestors, got %v", ancestors)
+	}
+	parent := ancestors[0].(map[string]interface{})
+	if parent["path"] != "/system.slice" || parent["limits"].(map[string]interface{})["memory_max"] != 1048576.0 {
+		t.Errorf("Expected /system.slice with its memory.max, got %v", parent)
+	}
+	if root := ancestors[1].(map[string]interface{}); root["path"] != "/" {
+		t.Errorf("Expected the root cgroup last, got %v", root)
+	}
+
+	for target, want := range map[string]int{
+		"/api/v1/pids/7":    http.StatusNotFound,
+		"/api/v1/pids/43":   http.StatusNotFound,
+		"/api/v1/pids/self": http.StatusBadRequest,
+	} {
+		var apiErr map[string]string
+		if code := getJSON(t, h, httptest.NewRequest(http.MethodGet, target, nil), &apiErr); code != want {
+			t.Errorf("%s: expected status %d, got %d", target, want, code)
+		}
+	}
+
+	// A process outside of the cgroup namespace gets an explanation
+	var apiErr map[string]string
+	if code := getJSON(t, h, httptest.NewRequest(http.MethodGet, "/api/v1/pids/44", nil), &apiErr); code != http.StatusNotFound || !strings.Contains(apiErr["error"], "cgroup namespace") {
+		t.Errorf("Expected a 404 naming the cgroup namespace, got %d %v", code, apiErr)
+	}
+}
@@ -0,0 +1,150 @@
+package web
+
+import (
+	"io/fs"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/collector"
+)
+
+// healthReporter is implemented by collectors that track their scrape health
+type healthReporter interface {
+	Health() collector.Health
+}
+
+// HealthHandler serves /health, the scrape health of every collector. A
+// collector is degraded while its scrapes fail. The status code stays 200 so
+// that liveness probes do not restart the exporter for a failing collector.
+// Tenants get no error messages, which contain paths of other cgroups.
+type HealthHandler struct {
+	collectors map[string]collector.Collector
+	logger     *logrus.Logger
+}
+
+// NewHealthHandler creates a health handler
+func NewHealthHandler(collectors map[string]collector.Collector, logger *logrus.Logger) *HealthHandler {
+	return &HealthHandler{
+		collectors: collectors,
+		logger:     logger,
+	}
+}
+
+// healthResponse is the response of /health
+type healthResponse struct {
+	Status     string                     `json:"status"`
+	Timestamp  time.Time                  `json:"timestamp"`
+	Degraded   []string                   `json:"degraded,omitempty"`
+	Collectors map[string]collectorHealth `json:"collectors"`
+}
+
+// collectorHealth is the scrape health of a collector
+type collectorHealth struct {
+	Status              string     `json:"status"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastDuration        float64    `json:"last_duration_seconds"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorTime       *time.Time `json:"last_error_time,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+// ServeHTTP implements http.Handler
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	response := healthResponse{
+		Status:     "healthy",
+		Timestamp:  time.Now(),
+		Collectors: make(map[string]collectorHealth),
+	}
+	tenant := TenantFromContext(r.Context())
+	for name, c := range h.collectors {
+		reporter, ok := c.(healthReporter)
+		if !ok {
+			continue
+		}
+		health := reporter.Health()
+		status := collectorHealth{
+			Status:              "healthy",
+			LastError:           health.LastError,
+			ConsecutiveFailures: health.ConsecutiveFailures,
+			LastDuration:        health.LastDuration.Seconds(),
+			LastSuccess:         timeOrNil(health.LastSuccess),
+			LastErrorTime:       timeOrNil(health.LastErrorTime),
+		}
+		if tenant != nil {
+			status.LastError = ""
+		}
+		if !health.Healthy() {
+			status.Status = "degraded"
+			response.Status = "degraded"
+			response.Degraded = append(response.Degraded, name)
+		}
+		response.Collectors[name] = status
+	}
+	sort.Strings(response.Degraded)
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// ReadyHandler serves /ready, which fails until the first scan of the
+// cgroup tree completes and whenever the cgroupfs root is inaccessible.
+// Tenants get the status without the error.
+type ReadyHandler struct {
+	sources collector.Sources
+	logger  *logrus.Logger
+}
+
+// NewReadyHandler creates a readiness handler. sources.Scanner must be the
+// scanner shared with the collectors.
+func NewReadyHandler(sources collector.Sources, logger *logrus.Logger) *ReadyHandler {
+	return &ReadyHandler{
+		sources: sources,
+		logger:  logger,
+	}
+}
+
+// readyResponse is the response of /ready
+type readyResponse struct {
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	Timestamp time.Time  `json:"timestamp"`
+	ScannedAt *time.Time `json:"scanned_at,omitempty"`
+}
+
+// ServeHTTP implements http.Handler
+func (h *ReadyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, scannedAt := h.sources.Scanner.Snapshot()
+	response := readyResponse{
+		Status:    "ready",
+		Timestamp: time.Now(),
+		ScannedAt: timeOrNil(scannedAt),
+	}
+
+	switch _, err := fs.Stat(h.sources.Cgroup, "cgroup.controllers"); {
+	case err != nil:
+		h.logger.WithError(err).Warn("cgroup v2 filesystem is not accessible")
+		response.Status = "not ready"
+		response.Error = "cgroup v2 controllers file not accessible: " + err.Error()
+	case scannedAt.IsZero():
+		response.Status = "not ready"
+		response.Error = "waiting for the first cgroup scan"
+	}
+
+	if response.Error != "" {
+		if TenantFromContext(r.Context()) != nil {
+			response.Error = ""
+		}
+		writeJSON(w, http.StatusServiceUnavailable, response)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
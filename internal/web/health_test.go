@@ -0,0 +1,111 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/collector"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/fsys"
+)
+
+// healthCollector is a fake collector with a fixed scrape health
+type healthCollector struct {
+	*fakeCollector
+	health collector.Health
+}
+
+func (c *healthCollector) Health() collector.Health { return c.health }
+
+func TestHealthHandler(t *testing.T) {
+	collectors := map[string]collector.Collector{
+		"cpu": &healthCollector{newFakeCollector("cpu"), collector.Health{LastSuccess: time.Now()}},
+		"io":  newFakeCollector("io"),
+	}
+	h := NewHealthHandler(collectors, logrus.New())
+
+	var response healthResponse
+	if code := getJSON(t, h, httptest.NewRequest(http.MethodGet, "/health", nil), &response); code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", code)
+	}
+	if response.Status != "healthy" || response.Collectors["cpu"].Status != "healthy" {
+		t.Errorf("Expected a healthy exporter, got %+v", response)
+	}
+
+	collectors["memory"] = &healthCollector{newFakeCollector("memory"), collector.Health{
+		LastError:           `open "memory.stat": permission denied`,
+		LastErrorTime:       time.Now(),
+		ConsecutiveFailures: 3,
+	}}
+	response = healthResponse{}
+	if code := getJSON(t, h, httptest.NewRequest(http.MethodGet, "/health", nil), &response); code != http.StatusOK {
+		t.Errorf("Expected status 200 for a degraded collector, got %d", code)
+	}
+	memory := response.Collectors["memory"]
+	if response.Status != "degraded" || len(response.Degraded) != 1 || response.Degraded[0] != "memory" {
+		t.Errorf("Expected the memory collector to be degraded, got %+v", response)
+	}
+	if memory.ConsecutiveFailures != 3 || memory.LastError != `open "memory.stat": permission denied` || memory.LastSuccess != nil {
+		t.Errorf("Unexpected health of the memory collector: %+v", memory)
+	}
+
+	// Error messages may contain paths of other tenants' cgroups
+	response = healthResponse{}
+	getJSON(t, h, newTenantRequest(t, "/health"), &response)
+	if memory := response.Collectors["memory"]; memory.Status != "degraded" || memory.LastError != "" {
+		t.Errorf("Expected the health without errors for a tenant, got %+v", memory)
+	}
+}
+
+// newTenantRequest returns a GET request of a tenant restricted to /a.slice
+func newTenantRequest(t *testing.T, target string) *http.Request {
+	t.Helper()
+
+	tenants, _, err := compileTenants([]TenantConfig{{Name: "a", BearerTokens: []string{"a"}, Cgroups: []string{"/a.slice"}}})
+	if err != nil {
+		t.Fatalf("compileTenants failed: %v", err)
+	}
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	return r.WithContext(withTenant(r.Context(), tenants[0]))
+}
+
+func TestReadyHandler(t *testing.T) {
+	cgroupFS := fsys.NewMem()
+	cfg := &config.Config{Advanced: config.AdvancedConfig{MaxCgroups: 100}}
+	sources := collector.Sources{Cgroup: cgroupFS}
+	sources.Scanner = collector.NewScanner(cfg, sources, logrus.New())
+	h := NewReadyHandler(sources, logrus.New())
+
+	ready := func() (int, readyResponse) {
+		var response readyResponse
+		code := getJSON(t, h, httptest.NewRequest(http.MethodGet, "/ready", nil), &response)
+		return code, response
+	}
+
+	if code, response := ready(); code != http.StatusServiceUnavailable || response.Error == "" {
+		t.Errorf("Expected not ready without cgroup.controllers, got %d: %+v", code, response)
+	}
+	var response readyResponse
+	if code := getJSON(t, h, newTenantRequest(t, "/ready"), &response); code != http.StatusServiceUnavailable || response.Error != "" {
+		t.Errorf("Expected not ready without the error for a tenant, got %d: %+v", code, response)
+	}
+
+	if err := cgroupFS.WriteFile("cgroup.controllers", []byte("cpu memory\n")); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if code, response := ready(); code != http.StatusServiceUnavailable || response.ScannedAt != nil {
+		t.Errorf("Expected not ready before the first scan, got %d: %+v", code, response)
+	}
+
+	if _, err := sources.Scanner.Scan(context.Background()); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if code, response := ready(); code != http.StatusOK || response.Status != "ready" || response.ScannedAt == nil {
+		t.Errorf("Expected ready after the first scan, got %d: %+v", code, response)
+	}
+}
@@ -0,0 +1,244 @@
+package web
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/version"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/collector"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+)
+
+// LandingPage serves the status page at /: the health of the collectors,
+// the cgroup counts of the latest scan, the enrichers, the kernel and
+// cgroupfs mount and the effective configuration with secrets redacted. The
+// page is host-wide, so tenants are refused.
+type LandingPage struct {
+	config     *config.Config
+	collectors map[string]collector.Collector
+	sources    collector.Sources
+	logger     *logrus.Logger
+}
+
+// NewLandingPage creates the status page. sources.Scanner must be the
+// scanner shared with the collectors.
+func NewLandingPage(cfg *config.Config, collectors map[string]collector.Collector, sources collector.Sources, logger *logrus.Logger) *LandingPage {
+	return &LandingPage{
+		config:     cfg,
+		collectors: collectors,
+		sources:    sources,
+		logger:     logger,
+	}
+}
+
+// landingData is the data rendered by landingTemplate
+type landingData struct {
+	Version       string
+	Revision      string
+	TelemetryPath string
+	Now           time.Time
+
+	Collectors []collectorStatus
+	Discovered int
+	Exported   int
+	MaxCgroups int
+	ScannedAt  time.Time
+	Enrichers  []string
+
+	Kernel         string
+	Mounts         []string
+	Controllers    string
+	SubtreeControl string
+	CgroupPath     string
+	// EffectiveConfig is the redacted configuration as YAML, empty if it
+	// could not be rendered
+	EffectiveConfig string
+}
+
+// collectorStatus is a row of the collector table
+type collectorStatus struct {
+	Name   string
+	Health *collector.Health
+}
+
+// ServeHTTP implements http.Handler
+func (p *LandingPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if TenantFromContext(r.Context()) != nil {
+		http.Error(w, "the status page is not available to tenants", http.StatusForbidden)
+		return
+	}
+
+	var page bytes.Buffer
+	if err := landingTemplate.Execute(&page, p.data()); err != nil {
+		p.logger.WithError(err).Error("Failed to render the status page")
+		http.Error(w, "failed to render the status page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page.Bytes())
+}
+
+// data gathers the current status
+func (p *LandingPage) data() *landingData {
+	cgroups, scannedAt := p.sources.Scanner.Snapshot()
+	data := &landingData{
+		Version:       version.Version,
+		Revision:      version.Revision,
+		TelemetryPath: p.config.Web.TelemetryPath,
+		Now:           time.Now(),
+		Discovered:    p.sources.Scanner.Discovered(),
+		Exported:      len(cgroups),
+		MaxCgroups:    p.config.Advanced.MaxCgroups,
+		ScannedAt:     scannedAt,
+		CgroupPath:    p.config.Cgroup.Path,
+	}
+	if p.config.Web.DisableMetricsEndpoint {
+		data.TelemetryPath = ""
+	}
+
+	for name, c := range p.collectors {
+		status := collectorStatus{Name: name}
+		if reporter, ok := c.(healthReporter); ok {
+			health := reporter.Health()
+			status.Health = &health
+		}
+		data.Collectors = append(data.Collectors, status)
+	}
+	sort.Slice(data.Collectors, func(i, j int) bool { return data.Collectors[i].Name < data.Collectors[j].Name })
+
+	for _, e := range p.sources.Enrichers {
+		data.Enrichers = append(data.Enrichers, e.Name())
+	}
+
+	if p.sources.Proc != nil {
+		data.Kernel = p.readProc("sys/kernel/osrelease")
+		data.Mounts = cgroup2Mounts(p.readProc("self/mountinfo"))
+	}
+	data.Controllers = p.readCgroup("cgroup.controllers")
+	data.SubtreeControl = p.readCgroup("cgroup.subtree_control")
+
+	if effective, err := yaml.Marshal(p.config.Redacted()); err == nil {
+		data.EffectiveConfig = string(effective)
+	} else {
+		p.logger.WithError(err).Warn("Failed to render the effective configuration")
+	}
+	return data
+}
+
+func (p *LandingPage) readProc(name string) string {
+	content, err := p.sources.Proc.ReadFile(name)
+	if err != nil {
+		p.logger.WithError(err).Debugf("Failed to read %s", name)
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+func (p *LandingPage) readCgroup(name string) string {
+	content, err := p.sources.Cgroup.ReadFile(name)
+	if err != nil {
+		p.logger.WithError(err).Debugf("Failed to read %s", name)
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// cgroup2Mounts returns the cgroup2 mounts of a mountinfo file as
+// "<mount point> (<mount options>,<super options>)"
+func cgroup2Mounts(mountinfo string) []string {
+	var mounts []string
+	for _, line := range strings.Split(mountinfo, "\n") {
+		// The optional fields end with a "-" separator, followed by the
+		// filesystem type, the source and the super block options
+		mount, fsInfo, ok := strings.Cut(line, " - ")
+		fields, fsFields := strings.Fields(mount), strings.Fields(fsInfo)
+		if !ok || len(fields) < 6 || len(fsFields) < 3 || fsFields[0] != "cgroup2" {
+			continue
+		}
+		mounts = append(mounts, fields[4]+" ("+fields[5]+","+fsFields[2]+")")
+	}
+	return mounts
+}
+
+var landingTemplate = template.Must(template.New("landing").Funcs(template.FuncMap{
+	"ago": func(now, t time.Time) string {
+		return now.Sub(t).Truncate(time.Millisecond).String() + " ago"
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>Prometheus cgroup v2 Exporter</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+.degraded { color: #b00; }
+pre { background: #f4f4f4; padding: 1em; }
+</style>
+</head>
+<body>
+<h1>Prometheus cgroup v2 Exporter</h1>
+<p>Version {{.Version}} (revision {{.Revision}})</p>
+<p>
+{{if .TelemetryPath}}<a href="{{.TelemetryPath}}">Metrics</a> |{{end}}
+<a href="/health">Health</a> |
+<a href="/ready">Ready</a> |
+<a href="/api/v1/cgroups">cgroups API</a>
+</p>
+
+<h2>Collectors</h2>
+<table>
+<tr><th>Collector</th><th>Status</th><th>Last success</th><th>Last duration</th><th>Consecutive failures</th><th>Last error</th></tr>
+{{- range .Collectors}}
+<tr>
+<td>{{.Name}}</td>
+{{- with .Health}}
+<td{{if not .Healthy}} class="degraded"{{end}}>{{if .Healthy}}healthy{{else}}degraded{{end}}</td>
+<td>{{if .LastSuccess.IsZero}}never{{else}}{{ago $.Now .LastSuccess}}{{end}}</td>
+<td>{{.LastDuration}}</td>
+<td>{{.ConsecutiveFailures}}</td>
+<td>{{.LastError}}{{if .LastError}} ({{ago $.Now .LastErrorTime}}){{end}}</td>
+{{- else}}
+<td colspan="5">unknown</td>
+{{- end}}
+</tr>
+{{- end}}
+</table>
+
+<h2>cgroups</h2>
+<table>
+<tr><th>Last scan</th><td>{{if .ScannedAt.IsZero}}pending{{else}}{{ago .Now .ScannedAt}}{{end}}</td></tr>
+<tr><th>Discovered</th><td>{{.Discovered}}</td></tr>
+<tr><th>Exported</th><td>{{.Exported}} (at most {{.MaxCgroups}})</td></tr>
+<tr><th>Enrichers</th><td>{{range $i, $e := .Enrichers}}{{if $i}}, {{end}}{{$e}}{{else}}none{{end}}</td></tr>
+</table>
+
+<h2>System</h2>
+<table>
+<tr><th>Kernel</th><td>{{or .Kernel "unknown"}}</td></tr>
+<tr><th>cgroupfs</th><td>{{.CgroupPath}}</td></tr>
+<tr><th>cgroup2 mounts</th><td>{{range .Mounts}}{{.}}<br>{{else}}unknown{{end}}</td></tr>
+<tr><th>Root controllers</th><td>{{.Controllers}}</td></tr>
+<tr><th>Root subtree_control</th><td>{{.SubtreeControl}}</td></tr>
+</table>
+
+<h2>Configuration</h2>
+{{- with .EffectiveConfig}}
+<pre>{{.}}</pre>
+{{- else}}
+<p>The configuration could not be rendered.</p>
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,159 @@
+// Package web implements the HTTP handlers of the exporter.
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/collector"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+)
+
+// MetricsHandler serves the collectors in the Prometheus exposition format.
+// Like node_exporter, the collectors of a single scrape can be selected with
+// collect[] and exclude[] query parameters, e.g.
+// /metrics?collect[]=cpu&collect[]=memory or /metrics?exclude[]=io.
+// Tenants only get the series of their own cgroups.
+type MetricsHandler struct {
+	config     *config.Config
+	scanner    *cgroup.Scanner
+	collectors map[string]collector.Collector
+	// exporter collectors are included in every response
+	exporter []prometheus.Collector
+	logger   *logrus.Logger
+
+	gatherer   prometheus.Gatherer
+	unfiltered http.Handler
+}
+
+// NewMetricsHandler creates a metrics handler for the given collectors and
+// exporter-level collectors such as the build info. Every scrape scans the
+// cgroups once with scanner, which the collectors must share.
+func NewMetricsHandler(cfg *config.Config, scanner *cgroup.Scanner, collectors map[string]collector.Collector, logger *logrus.Logger, exporter ...prometheus.Collector) (*MetricsHandler, error) {
+	h := &MetricsHandler{
+		config:     cfg,
+		scanner:    scanner,
+		collectors: collectors,
+		exporter:   exporter,
+		logger:     logger,
+	}
+
+	registry, err := h.registry(h.names(), nil)
+	if err != nil {
+		return nil, err
+	}
+	h.gatherer = collector.NewGatherer(scanner, registry)
+	h.unfiltered = h.handlerFor(h.gatherer)
+	return h, nil
+}
+
+// Gatherer returns the gatherer of all collectors, which scans on every
+// gather, for pushing the metrics
+func (h *MetricsHandler) Gatherer() prometheus.Gatherer {
+	return h.gatherer
+}
+
+// ServeHTTP implements http.Handler
+func (h *MetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	collect, exclude := query["collect[]"], query["exclude[]"]
+	tenant := TenantFromContext(r.Context())
+	if len(collect) == 0 && len(exclude) == 0 && tenant == nil {
+		h.unfiltered.ServeHTTP(w, r)
+		return
+	}
+
+	names, err := h.filter(collect, exclude)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var scope collector.Scope
+	if tenant != nil {
+		scope = tenant.Allowed
+	}
+	registry, err := h.registry(names, scope)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to create filtered registry")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.handlerFor(collector.NewGatherer(h.scanner, registry)).ServeHTTP(w, r)
+}
+
+// filter returns the enabled collectors selected by collect[] (all if
+// empty) minus those in exclude[]. Unknown names are an error.
+func (h *MetricsHandler) filter(collect, exclude []string) ([]string, error) {
+	for _, name := range append(append([]string(nil), collect...), exclude...) {
+		if _, ok := h.collectors[name]; !ok {
+			return nil, fmt.Errorf("unknown or disabled collector: %s", name)
+		}
+	}
+
+	selected := make(map[string]bool)
+	if len(collect) == 0 {
+		for _, name := range h.names() {
+			selected[name] = true
+		}
+	}
+	for _, name := range collect {
+		selected[name] = true
+	}
+	for _, name := range exclude {
+		delete(selected, name)
+	}
+
+	names := make([]string, 0, len(selected))
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// registry creates a registry with the exporter collectors and the named
+// collectors, restricted to the scope if not nil
+func (h *MetricsHandler) registry(names []string, scope collector.Scope) (*prometheus.Registry, error) {
+	registry := prometheus.NewRegistry()
+	for _, c := range h.exporter {
+		if err := registry.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range names {
+		var c prometheus.Collector = h.collectors[name]
+		if scope != nil {
+			c = collector.Scoped(h.collectors[name], scope)
+		}
+		if err := registry.Register(c); err != nil {
+			return nil, fmt.Errorf("failed to register collector %s: %w", name, err)
+		}
+	}
+	return registry, nil
+}
+
+func (h *MetricsHandler) handlerFor(gatherer prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{
+		ErrorLog:                            h.logger,
+		ErrorHandling:                       promhttp.ContinueOnError,
+		EnableOpenMetrics:                   true,
+		EnableOpenMetricsTextCreatedSamples: h.config.Web.OpenMetricsCreatedSamples,
+	})
+}
+
+// names returns the names of all collectors in order
+func (h *MetricsHandler) names() []string {
+	names := make([]string, 0, len(h.collectors))
+	for name := range h.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
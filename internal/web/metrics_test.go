@@ -0,0 +1,141 @@
+package web
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/cgroup"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/collector"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/fsys"
+)
+
+// fakeCollector exports a single test_<name> gauge
+type fakeCollector struct {
+	name string
+	desc *prometheus.Desc
+}
+
+func newFakeCollector(name string) *fakeCollector {
+	return &fakeCollector{
+		name: name,
+		desc: prometheus.NewDesc("test_"+name, "Test metric.", nil, nil),
+	}
+}
+
+func (c *fakeCollector) Name() string  { return c.name }
+func (c *fakeCollector) Enabled() bool { return true }
+
+func (c *fakeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *fakeCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1)
+}
+
+func newTestMetricsHandler(t *testing.T) *MetricsHandler {
+	t.Helper()
+
+	collectors := make(map[string]collector.Collector)
+	for _, name := range []string{"cpu", "memory", "io"} {
+		collectors[name] = newFakeCollector(name)
+	}
+	exporter := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_build_info", Help: "Test build info."})
+
+	h, err := NewMetricsHandler(&config.Config{}, cgroup.NewScanner(fsys.NewMem(), logrus.New()), collectors, logrus.New(), exporter)
+	if err != nil {
+		t.Fatalf("NewMetricsHandler failed: %v", err)
+	}
+	return h
+}
+
+func TestMetricsHandler(t *testing.T) {
+	h := newTestMetricsHandler(t)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"all", "", []string{"test_build_info", "test_cpu", "test_memory", "test_io"}},
+		{"collect", "?collect[]=cpu&collect[]=memory", []string{"test_build_info", "test_cpu", "test_memory"}},
+		{"exclude", "?exclude[]=io", []string{"test_build_info", "test_cpu", "test_memory"}},
+		{"collect and exclude", "?collect[]=cpu&collect[]=io&exclude[]=io", []string{"test_build_info", "test_cpu"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics"+tt.query, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", rec.Code)
+			}
+
+			body, _ := io.ReadAll(rec.Body)
+			found := 0
+			for _, line := range strings.Split(string(body), "\n") {
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				found++
+				name := strings.Fields(line)[0]
+				if !contains(tt.want, name) {
+					t.Errorf("Unexpected metric %s", name)
+				}
+			}
+			if found != len(tt.want) {
+				t.Errorf("Expected %d metrics, got %d:\n%s", len(tt.want), found, body)
+			}
+		})
+	}
+}
+
+func TestMetricsHandler_UnknownCollector(t *testing.T) {
+	h := newTestMetricsHandler(t)
+
+	for _, query := range []string{"?collect[]=network", "?exclude[]=pids"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMetricsHandler_OpenMetrics(t *testing.T) {
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_events_total", Help: "Test counter."})
+	cfg := &config.Config{Web: config.WebConfig{OpenMetricsCreatedSamples: true}}
+	h, err := NewMetricsHandler(cfg, cgroup.NewScanner(fsys.NewMem(), logrus.New()), map[string]collector.Collector{}, logrus.New(), counter)
+	if err != nil {
+		t.Fatalf("NewMetricsHandler failed: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/openmetrics-text") {
+		t.Errorf("Expected OpenMetrics, got content type %q", contentType)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	if !strings.Contains(string(body), "test_events_created ") {
+		t.Errorf("Expected a _created sample, got:\n%s", body)
+	}
+}
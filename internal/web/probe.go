@@ -0,0 +1,145 @@
+package web
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/collector"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/fsys"
+)
+
+// ProbeHandler serves /probe?target=<path>, which runs the enabled
+// collectors against an alternate cgroupfs root such as the mounted cgroupfs
+// of a nested container or a delegated subtree. Targets must be in the
+// probe.allowed_roots allow-list. Like /metrics, probes support collect[]
+// and exclude[]. Tenants cannot probe, since their patterns match the paths
+// of the host hierarchy rather than the ones of a probed root.
+type ProbeHandler struct {
+	config  *config.Config
+	sources collector.Sources
+	logger  *logrus.Logger
+
+	mutex sync.Mutex
+	// targets holds the collectors of each probed root, so that their
+	// caches survive across probes. A target is dropped once its root is
+	// no longer a cgroup v2 root.
+	targets map[string]*probeTarget
+	// newFS opens a target root, fsys.NewOS outside of tests
+	newFS func(root string) fsys.FS
+}
+
+// probeTarget is a probed root with its collectors
+type probeTarget struct {
+	fs      fsys.FS
+	handler *MetricsHandler
+}
+
+// NewProbeHandler creates a probe handler. The targets share the procfs and
+// the enrichers of sources.
+func NewProbeHandler(cfg *config.Config, sources collector.Sources, logger *logrus.Logger) *ProbeHandler {
+	return &ProbeHandler{
+		config:  cfg,
+		sources: sources,
+		logger:  logger,
+		targets: make(map[string]*probeTarget),
+		newFS:   func(root string) fsys.FS { return fsys.NewOS(root) },
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (h *ProbeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if TenantFromContext(r.Context()) != nil {
+		http.Error(w, "probes are not available to tenants", http.StatusForbidden)
+		return
+	}
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "missing target parameter", http.StatusBadRequest)
+		return
+	}
+	if !h.allowed(target) {
+		http.Error(w, fmt.Sprintf("target %q is not an allowed cgroup root", target), http.StatusForbidden)
+		return
+	}
+
+	handler, err := h.handler(target)
+	if err != nil {
+		h.logger.WithError(err).WithField("target", target).Warn("Failed to probe target")
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// allowed reports whether a target matches the allow-list. Targets must be
+// clean absolute paths, so that ".." cannot escape an allowed pattern.
+func (h *ProbeHandler) allowed(target string) bool {
+	if !filepath.IsAbs(target) || filepath.Clean(target) != target {
+		return false
+	}
+	for _, root := range h.config.Probe.AllowedRoots {
+		if ok, _ := filepath.Match(root, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// handler returns the metrics handler of a target, creating its collectors
+// on the first probe
+func (h *ProbeHandler) handler(target string) (*MetricsHandler, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if t, ok := h.targets[target]; ok {
+		if err := checkRoot(target, t.fs); err != nil {
+			h.logger.WithField("target", target).Info("Dropped collectors of removed probe target")
+			delete(h.targets, target)
+			return nil, err
+		}
+		return t.handler, nil
+	}
+
+	cgroupFS := h.newFS(target)
+	if err := checkRoot(target, cgroupFS); err != nil {
+		return nil, err
+	}
+	// Roots of containers come and go, so drop the removed ones before
+	// adding another
+	for root, t := range h.targets {
+		if checkRoot(root, t.fs) != nil {
+			h.logger.WithField("target", root).Info("Dropped collectors of removed probe target")
+			delete(h.targets, root)
+		}
+	}
+
+	sources := h.sources
+	sources.Cgroup = cgroupFS
+	sources.Scanner = collector.NewScanner(h.config, sources, h.logger)
+	collectors, err := collector.NewCollectorsWithSources(h.config, sources, h.logger)
+	if err != nil {
+		return nil, err
+	}
+	handler, err := NewMetricsHandler(h.config, sources.Scanner, collectors, h.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	h.logger.WithField("target", target).Info("Created collectors for probe target")
+	h.targets[target] = &probeTarget{fs: cgroupFS, handler: handler}
+	return handler, nil
+}
+
+// checkRoot returns an error unless root is a cgroup v2 root
+func checkRoot(target string, root fsys.FS) error {
+	if _, err := fs.Stat(root, "cgroup.controllers"); err != nil {
+		return fmt.Errorf("target %q is not a cgroup v2 root: %w", target, err)
+	}
+	return nil
+}
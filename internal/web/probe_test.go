@@ -0,0 +1,115 @@
+package web
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/collector"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/fsys"
+)
+
+func TestProbeHandler(t *testing.T) {
+	roots := map[string]*fsys.MemFS{
+		"/var/lib/machines/web/sys/fs/cgroup": fsys.NewMem(),
+		"/var/lib/machines/db/sys/fs/cgroup":  fsys.NewMem(),
+	}
+	for root, mem := range roots {
+		for _, dir := range []string{"", "app.service/"} {
+			if err := mem.WriteFile(dir+"cgroup.controllers", []byte("cpu\n")); err != nil {
+				t.Fatalf("WriteFile failed: %v", err)
+			}
+		}
+		if strings.Contains(root, "/db/") {
+			if err := mem.WriteFile("db.service/cgroup.controllers", []byte("cpu\n")); err != nil {
+				t.Fatalf("WriteFile failed: %v", err)
+			}
+		}
+	}
+
+	cfg := &config.Config{
+		Probe: config.ProbeConfig{AllowedRoots: []string{
+			"/var/lib/machines/*/sys/fs/cgroup",
+			"/sys/fs/cgroup/delegated.slice",
+		}},
+		Collectors: config.CollectorsConfig{Info: config.InfoCollectorConfig{Enabled: true}},
+		Advanced:   config.AdvancedConfig{MaxCgroups: 100},
+	}
+	h := NewProbeHandler(cfg, collector.Sources{}, logrus.New())
+	h.newFS = func(root string) fsys.FS {
+		if mem, ok := roots[root]; ok {
+			return mem
+		}
+		return fsys.NewMem()
+	}
+
+	probe := func(target string) (int, string) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe?target="+url.QueryEscape(target), nil))
+		body, _ := io.ReadAll(rec.Body)
+		return rec.Code, string(body)
+	}
+
+	code, body := probe("/var/lib/machines/db/sys/fs/cgroup")
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", code, body)
+	}
+	if !strings.Contains(body, `cgroup="db.service"`) {
+		t.Errorf("Expected the cgroups of the target, got:\n%s", body)
+	}
+
+	code, body = probe("/var/lib/machines/web/sys/fs/cgroup")
+	if code != http.StatusOK || strings.Contains(body, `cgroup="db.service"`) {
+		t.Errorf("Expected only the cgroups of the web target, got status %d:\n%s", code, body)
+	}
+
+	// Collectors of removed roots are dropped, when probed again or when
+	// another root is probed
+	for _, root := range []string{"/var/lib/machines/db/sys/fs/cgroup", "/var/lib/machines/web/sys/fs/cgroup"} {
+		if err := roots[root].RemoveAll("cgroup.controllers"); err != nil {
+			t.Fatalf("RemoveAll failed: %v", err)
+		}
+	}
+	if code, _ := probe("/var/lib/machines/db/sys/fs/cgroup"); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a removed target, got %d", code)
+	}
+	roots["/var/lib/machines/app/sys/fs/cgroup"] = fsys.NewMem()
+	if err := roots["/var/lib/machines/app/sys/fs/cgroup"].WriteFile("cgroup.controllers", []byte("cpu\n")); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if code, body := probe("/var/lib/machines/app/sys/fs/cgroup"); code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", code, body)
+	}
+	if _, ok := h.targets["/var/lib/machines/app/sys/fs/cgroup"]; !ok || len(h.targets) != 1 {
+		t.Errorf("Expected only the collectors of the remaining target, got %v", h.targets)
+	}
+
+	tests := map[string]int{
+		"":               http.StatusBadRequest,
+		"/sys/fs/cgroup": http.StatusForbidden,
+		"/var/lib/machines/web/../db/sys/fs/cgroup": http.StatusForbidden,
+		"/var/lib/machines/web/sys/fs/cgroup/":      http.StatusForbidden,
+		"/sys/fs/cgroup/delegated.slice":            http.StatusNotFound,
+	}
+	for target, want := range tests {
+		if code, _ := probe(target); code != want {
+			t.Errorf("Target %q: expected status %d, got %d", target, want, code)
+		}
+	}
+	tenants, _, err := compileTenants([]TenantConfig{{Name: "a", BearerTokens: []string{"a"}, Cgroups: []string{"/app.service"}}})
+	if err != nil {
+		t.Fatalf("compileTenants failed: %v", err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/probe?target="+url.QueryEscape("/var/lib/machines/db/sys/fs/cgroup"), nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r.WithContext(withTenant(r.Context(), tenants[0])))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a tenant, got %d", rec.Code)
+	}
+}
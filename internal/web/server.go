@@ -0,0 +1,232 @@
+package web
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxAuthCacheSize bounds the cache of verified basic auth credentials
+const maxAuthCacheSize = 1024
+
+// Server is an HTTP server secured by an optional web configuration file.
+// The file and the certificates it references are reloaded when they change,
+// so certificates and users can be rotated without a restart. Switching
+// between HTTP and HTTPS requires a restart.
+type Server struct {
+	server     *http.Server
+	configFile string
+	logger     *logrus.Logger
+
+	mutex     sync.Mutex
+	stamp     string
+	config    *Config
+	tlsConfig *tls.Config
+	// authCache remembers bcrypt comparisons, which are deliberately slow
+	authCache map[[sha256.Size]byte]bool
+}
+
+// NewServer secures server with the web configuration file, if any
+func NewServer(server *http.Server, configFile string, logger *logrus.Logger) (*Server, error) {
+	s := &Server{
+		server:     server,
+		configFile: configFile,
+		logger:     logger,
+		config:     &Config{HTTPServerConfig: HTTPConfig{HTTP2: true}},
+		authCache:  make(map[[sha256.Size]byte]bool),
+	}
+
+	if configFile != "" {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+	}
+	if s.tlsConfig != nil && !s.config.HTTPServerConfig.HTTP2 {
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+
+	server.Handler = s.handler(server.Handler)
+	return s, nil
+}
+
+// TLS reports whether the server serves HTTPS
+func (s *Server) TLS() bool {
+	return s.tlsConfig != nil
+}
+
+// ListenAndServe listens on the server address and serves HTTP or HTTPS
+func (s *Server) ListenAndServe() error {
+	addr := s.server.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve serves HTTP or HTTPS on the listener
+func (s *Server) Serve(ln net.Listener) error {
+	if s.tlsConfig == nil {
+		return s.server.Serve(ln)
+	}
+
+	s.server.TLSConfig = &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			_, tlsConfig := s.current()
+			return tlsConfig, nil
+		},
+	}
+	return s.server.ServeTLS(ln, "", "")
+}
+
+// Shutdown gracefully shuts down the server
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// handler adds the configured headers and authenticates requests
+func (s *Server) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config, _ := s.current()
+		for name, value := range config.HTTPServerConfig.Headers {
+			w.Header().Set(name, value)
+		}
+
+		// Tenants only see their own cgroups. Everyone else needs basic
+		// auth, if configured, or a verified client certificate when there
+		// are tenants.
+		tenant, err := config.tenant(r)
+		switch {
+		case err != nil:
+			s.unauthorized(w)
+			return
+		case tenant != nil:
+			r = r.WithContext(withTenant(r.Context(), tenant))
+		case len(config.Users) > 0:
+			user, password, ok := r.BasicAuth()
+			if !ok || !s.authenticate(config, user, password) {
+				s.unauthorized(w)
+				return
+			}
+		case len(config.tenants) > 0:
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				s.unauthorized(w)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="prometheus-cgroup-v2-exporter"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// dummyHash is compared against for unknown users, so that they take as
+// long to reject as wrong passwords
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+	return hash
+})
+
+// authenticate checks basic auth credentials against the configured users
+func (s *Server) authenticate(config *Config, user, password string) bool {
+	hash, known := config.Users[user]
+	key := sha256.Sum256([]byte(user + "\x00" + hash + "\x00" + password))
+
+	s.mutex.Lock()
+	ok, cached := s.authCache[key]
+	s.mutex.Unlock()
+	if cached {
+		return ok
+	}
+
+	if known {
+		ok = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	} else {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+	}
+
+	s.mutex.Lock()
+	if len(s.authCache) >= maxAuthCacheSize {
+		s.authCache = make(map[[sha256.Size]byte]bool)
+	}
+	s.authCache[key] = ok
+	s.mutex.Unlock()
+	return ok
+}
+
+// current returns the web configuration, reloading it first if the file or
+// the files it references changed. An invalid configuration is logged and
+// the previous one stays in effect.
+func (s *Server) current() (*Config, *tls.Config) {
+	if s.configFile == "" {
+		return s.config, s.tlsConfig
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stamp := s.stampOf(s.config)
+	if stamp != s.stamp {
+		if err := s.load(); err != nil {
+			s.logger.WithError(err).Error("Failed to reload web configuration, keeping the previous one")
+			s.stamp = stamp
+		} else {
+			s.logger.WithField("file", s.configFile).Info("Reloaded web configuration")
+		}
+	}
+	return s.config, s.tlsConfig
+}
+
+// load reads the configuration file and the certificates. It is called
+// with the mutex held, or before the server is started.
+func (s *Server) load() error {
+	config, err := LoadConfig(s.configFile)
+	if err != nil {
+		return err
+	}
+	if s.stamp != "" && config.TLSServerConfig.Enabled() != (s.tlsConfig != nil) {
+		return fmt.Errorf("enabling or disabling TLS in %s requires a restart", s.configFile)
+	}
+
+	var tlsConfig *tls.Config
+	if config.TLSServerConfig.Enabled() {
+		if tlsConfig, err = config.tlsConfig(); err != nil {
+			return err
+		}
+	}
+
+	s.stamp = s.stampOf(config)
+	s.config, s.tlsConfig = config, tlsConfig
+	s.authCache = make(map[[sha256.Size]byte]bool)
+	return nil
+}
+
+// stampOf identifies the versions of the configuration file and of the
+// files referenced by config
+func (s *Server) stampOf(config *Config) string {
+	var stamp strings.Builder
+	for _, file := range append([]string{s.configFile}, config.TLSServerConfig.files()...) {
+		if info, err := os.Stat(file); err == nil {
+			fmt.Fprintf(&stamp, "%s:%d:%d;", file, info.ModTime().UnixNano(), info.Size())
+		} else {
+			fmt.Fprintf(&stamp, "%s:-;", file)
+		}
+	}
+	return stamp.String()
+}
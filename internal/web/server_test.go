@@ -0,0 +1,245 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeCert writes a self-signed certificate for 127.0.0.1, usable both as
+// server and client certificate and as CA, to dir/<name>.crt and .key
+func writeCert(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey failed: %v", err)
+	}
+
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	writeFile(t, certFile, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	writeFile(t, keyFile, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+	return certFile, keyFile
+}
+
+// writeFile writes a file with a modification time distinct from its
+// previous version
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	mtime := time.Now()
+	if info, err := os.Stat(path); err == nil && !info.ModTime().Before(mtime) {
+		mtime = info.ModTime().Add(time.Second)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+}
+
+func hashPassword(t *testing.T, password string) string {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword failed: %v", err)
+	}
+	return string(hash)
+}
+
+// startServer serves a handler answering "ok" with the web configuration
+// file and returns its address
+func startServer(t *testing.T, configFile string) (*Server, string) {
+	t.Helper()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})
+	server, err := NewServer(&http.Server{Handler: handler}, configFile, logrus.New())
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	go server.Serve(ln)
+	t.Cleanup(func() { server.server.Close() })
+	return server, ln.Addr().String()
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "server")
+
+	tests := map[string]string{
+		"unknown field":  "basic_auth_user:\n  admin: secret\n",
+		"plain password": "basic_auth_users:\n  admin: secret\n",
+		"missing key":    fmt.Sprintf("tls_server_config:\n  cert_file: %s\n", certFile),
+		"ca without client auth": fmt.Sprintf("tls_server_config:\n  cert_file: %s\n  key_file: %s\n  client_ca_file: %s\n",
+			certFile, keyFile, certFile),
+		"verify without ca": fmt.Sprintf("tls_server_config:\n  cert_file: %s\n  key_file: %s\n  client_auth_type: RequireAndVerifyClientCert\n",
+			certFile, keyFile),
+		"unknown tls version": fmt.Sprintf("tls_server_config:\n  cert_file: %s\n  key_file: %s\n  min_version: SSL3\n",
+			certFile, keyFile),
+	}
+
+	for name, content := range tests {
+		path := filepath.Join(dir, "web.yml")
+		writeFile(t, path, content)
+		if _, err := LoadConfig(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestServer_BasicAuth(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "web.yml")
+	writeFile(t, configFile, fmt.Sprintf(`basic_auth_users:
+  prometheus: %s
+http_server_config:
+  headers:
+    X-Frame-Options: deny
+`, hashPassword(t, "secret")))
+
+	_, addr := startServer(t, configFile)
+
+	get := func(user, password string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/metrics", nil)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	tests := []struct {
+		user, password string
+		want           int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"prometheus", "wrong", http.StatusUnauthorized},
+		{"nobody", "secret", http.StatusUnauthorized},
+		{"prometheus", "secret", http.StatusOK},
+		{"prometheus", "secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		resp := get(tt.user, tt.password)
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s:%s: expected status %d, got %d", tt.user, tt.password, tt.want, resp.StatusCode)
+		}
+		if resp.Header.Get("X-Frame-Options") != "deny" {
+			t.Errorf("Expected the configured X-Frame-Options header, got %q", resp.Header.Get("X-Frame-Options"))
+		}
+	}
+
+	// Users are reloaded when the file changes
+	writeFile(t, configFile, fmt.Sprintf("basic_auth_users:\n  prometheus: %s\n", hashPassword(t, "rotated")))
+	if resp := get("prometheus", "secret"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected the old password to be rejected after reload, got status %d", resp.StatusCode)
+	}
+	if resp := get("prometheus", "rotated"); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the new password to be accepted after reload, got status %d", resp.StatusCode)
+	}
+
+	// An invalid file keeps the previous configuration
+	writeFile(t, configFile, "basic_auth_users:\n  prometheus: rotated\n")
+	if resp := get("prometheus", "rotated"); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the previous configuration to stay in effect, got status %d", resp.StatusCode)
+	}
+}
+
+func TestServer_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "server")
+	clientCert, clientKey := writeCert(t, dir, "client")
+	configFile := filepath.Join(dir, "web.yml")
+	writeFile(t, configFile, fmt.Sprintf(`tls_server_config:
+  cert_file: %s
+  key_file: %s
+  client_auth_type: RequireAndVerifyClientCert
+  client_ca_file: %s
+  min_version: TLS13
+`, certFile, keyFile, clientCert))
+
+	server, addr := startServer(t, configFile)
+	if !server.TLS() {
+		t.Fatal("Expected the server to serve HTTPS")
+	}
+
+	serverCA, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(serverCA)
+
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		}}
+	}
+
+	if resp, err := client().Get("https://" + addr + "/metrics"); err == nil {
+		resp.Body.Close()
+		t.Error("Expected a request without client certificate to fail")
+	}
+
+	cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatalf("LoadX509KeyPair failed: %v", err)
+	}
+	resp, err := client(cert).Get("https://" + addr + "/metrics")
+	if err != nil {
+		t.Fatalf("Request with client certificate failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if resp.TLS == nil || resp.TLS.Version != tls.VersionTLS13 {
+		t.Error("Expected a TLS 1.3 connection")
+	}
+}
@@ -0,0 +1,162 @@
+package web
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// TenantConfig grants a tenant access to the series of cgroup subtrees
+type TenantConfig struct {
+	Name string `yaml:"name"`
+	// BearerTokens authenticate the tenant with an
+	// "Authorization: Bearer <token>" header
+	BearerTokens []string `yaml:"bearer_tokens"`
+	// ClientCertSubjects authenticate the tenant by a verified client
+	// certificate whose common name or RFC 2253 subject is listed
+	ClientCertSubjects []string `yaml:"client_cert_subjects"`
+	// Cgroups are the roots of the visible subtrees, e.g.
+	// "/user.slice/user-1000.slice". Path components may be shell patterns,
+	// e.g. "/kubepods.slice/*/kubepods-*-pod<uid>.slice".
+	Cgroups []string `yaml:"cgroups"`
+}
+
+// Tenant is an authenticated tenant, restricted to its cgroup subtrees
+type Tenant struct {
+	Name     string
+	subjects map[string]bool
+	roots    [][]string
+}
+
+// errInvalidToken rejects bearer tokens of no tenant
+var errInvalidToken = errors.New("invalid bearer token")
+
+// compileTenants validates the tenants and indexes them by bearer token
+func compileTenants(configs []TenantConfig) ([]*Tenant, map[[sha256.Size]byte]*Tenant, error) {
+	tenants := make([]*Tenant, 0, len(configs))
+	tokens := make(map[[sha256.Size]byte]*Tenant)
+	names := make(map[string]bool)
+
+	for _, cfg := range configs {
+		if cfg.Name == "" {
+			return nil, nil, fmt.Errorf("tenants: missing name")
+		}
+		if names[cfg.Name] {
+			return nil, nil, fmt.Errorf("tenants: duplicate tenant %q", cfg.Name)
+		}
+		names[cfg.Name] = true
+		if len(cfg.BearerTokens) == 0 && len(cfg.ClientCertSubjects) == 0 {
+			return nil, nil, fmt.Errorf("tenant %q: no bearer_tokens or client_cert_subjects", cfg.Name)
+		}
+		if len(cfg.Cgroups) == 0 {
+			return nil, nil, fmt.Errorf("tenant %q: no cgroups", cfg.Name)
+		}
+
+		tenant := &Tenant{Name: cfg.Name, subjects: make(map[string]bool)}
+		for _, subject := range cfg.ClientCertSubjects {
+			tenant.subjects[subject] = true
+		}
+		for _, root := range cfg.Cgroups {
+			if !strings.HasPrefix(root, "/") {
+				return nil, nil, fmt.Errorf("tenant %q: cgroup %q is not an absolute path", cfg.Name, root)
+			}
+			components := splitCgroupPath(root)
+			for _, pattern := range components {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, nil, fmt.Errorf("tenant %q: invalid cgroup pattern %q: %w", cfg.Name, root, err)
+				}
+			}
+			tenant.roots = append(tenant.roots, components)
+		}
+		for _, token := range cfg.BearerTokens {
+			key := sha256.Sum256([]byte(token))
+			if token == "" || tokens[key] != nil {
+				return nil, nil, fmt.Errorf("tenant %q: empty or duplicate bearer token", cfg.Name)
+			}
+			tokens[key] = tenant
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, tokens, nil
+}
+
+// Allowed reports whether a cgroup, given by its kernel path, lies within
+// one of the tenant's subtrees
+func (t *Tenant) Allowed(cgroupPath string) bool {
+	components := splitCgroupPath(cgroupPath)
+	for _, root := range t.roots {
+		if len(components) < len(root) {
+			continue
+		}
+		matched := true
+		for i, pattern := range root {
+			if ok, _ := path.Match(pattern, components[i]); !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesSubject reports whether a client certificate subject identifies
+// the tenant
+func (t *Tenant) matchesSubject(subject pkix.Name) bool {
+	return t.subjects[subject.String()] || (subject.CommonName != "" && t.subjects[subject.CommonName])
+}
+
+// tenant identifies the tenant of a request by its bearer token or its
+// verified client certificate. It returns nil for other requests.
+func (c *Config) tenant(r *http.Request) (*Tenant, error) {
+	if len(c.tenants) == 0 {
+		return nil, nil
+	}
+
+	auth := r.Header.Get("Authorization")
+	if scheme, token, ok := strings.Cut(auth, " "); ok && strings.EqualFold(scheme, "Bearer") {
+		tenant, ok := c.tokens[sha256.Sum256([]byte(strings.TrimSpace(token)))]
+		if !ok {
+			return nil, errInvalidToken
+		}
+		return tenant, nil
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		subject := r.TLS.VerifiedChains[0][0].Subject
+		for _, tenant := range c.tenants {
+			if tenant.matchesSubject(subject) {
+				return tenant, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func splitCgroupPath(cgroupPath string) []string {
+	cgroupPath = strings.Trim(cgroupPath, "/")
+	if cgroupPath == "" {
+		return nil
+	}
+	return strings.Split(cgroupPath, "/")
+}
+
+type tenantKey struct{}
+
+// TenantFromContext returns the tenant a request was authenticated as, or
+// nil if the request may see all cgroups
+func TenantFromContext(ctx context.Context) *Tenant {
+	tenant, _ := ctx.Value(tenantKey{}).(*Tenant)
+	return tenant
+}
+
+func withTenant(ctx context.Context, tenant *Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
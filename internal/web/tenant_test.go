@@ -0,0 +1,138 @@
+package web
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/collector"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/config"
+	"github.com/stillalive04/prometheus-cgroup-v2-exporter/internal/fsys"
+)
+
+func TestTenant_Allowed(t *testing.T) {
+	tenants, _, err := compileTenants([]TenantConfig{{
+		Name:         "shop",
+		BearerTokens: []string{"token"},
+		Cgroups: []string{
+			"/user.slice/user-1000.slice",
+			"/kubepods.slice/*/kubepods-*-pod1234*.slice",
+		},
+	}})
+	if err != nil {
+		t.Fatalf("compileTenants failed: %v", err)
+	}
+	tenant := tenants[0]
+
+	tests := map[string]bool{
+		"/user.slice/user-1000.slice":                 true,
+		"/user.slice/user-1000.slice/session-1.scope": true,
+		"/user.slice/user-10000.slice":                false,
+		"/user.slice":                                 false,
+		"/":                                           false,
+		"/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1234_5678.slice":   true,
+		"/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod9999_5678.slice": false,
+		"/kubepods.slice/kubepods-burstable.slice":                                         false,
+	}
+	for path, want := range tests {
+		if got := tenant.Allowed(path); got != want {
+			t.Errorf("Allowed(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestCompileTenants_Invalid(t *testing.T) {
+	tests := map[string]TenantConfig{
+		"missing name":     {BearerTokens: []string{"a"}, Cgroups: []string{"/a.slice"}},
+		"no credentials":   {Name: "a", Cgroups: []string{"/a.slice"}},
+		"no cgroups":       {Name: "a", BearerTokens: []string{"a"}},
+		"relative cgroup":  {Name: "a", BearerTokens: []string{"a"}, Cgroups: []string{"a.slice"}},
+		"invalid pattern":  {Name: "a", BearerTokens: []string{"a"}, Cgroups: []string{"/[a.slice"}},
+		"empty token":      {Name: "a", BearerTokens: []string{""}, Cgroups: []string{"/a.slice"}},
+		"duplicate tokens": {Name: "a", BearerTokens: []string{"a", "a"}, Cgroups: []string{"/a.slice"}},
+	}
+
+	for name, cfg := range tests {
+		if _, _, err := compileTenants([]TenantConfig{cfg}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestServer_Tenants(t *testing.T) {
+	cgroupFS := fsys.NewMem()
+	for _, dir := range []string{"", "a.slice/", "a.slice/app.service/", "b.slice/"} {
+		if err := cgroupFS.WriteFile(dir+"cgroup.controllers", []byte("cpu\n")); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	cfg := &config.Config{
+		Collectors: config.CollectorsConfig{Info: config.InfoCollectorConfig{Enabled: true}},
+		Advanced:   config.AdvancedConfig{MaxCgroups: 100},
+	}
+	sources := collector.Sources{Cgroup: cgroupFS}
+	sources.Scanner = collector.NewScanner(cfg, sources, logrus.New())
+	collectors, err := collector.NewCollectorsWithSources(cfg, sources, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create collectors: %v", err)
+	}
+	metrics, err := NewMetricsHandler(cfg, sources.Scanner, collectors, logrus.New())
+	if err != nil {
+		t.Fatalf("NewMetricsHandler failed: %v", err)
+	}
+
+	configFile := filepath.Join(t.TempDir(), "web.yml")
+	writeFile(t, configFile, fmt.Sprintf(`basic_auth_users:
+  admin: %s
+tenants:
+  - name: team-a
+    bearer_tokens: [secret-a]
+    cgroups: [/a.slice]
+`, hashPassword(t, "admin")))
+	server, err := NewServer(&http.Server{Handler: metrics}, configFile, logrus.New())
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	scrape := func(setAuth func(*http.Request)) (int, string) {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		setAuth(req)
+		rec := httptest.NewRecorder()
+		server.server.Handler.ServeHTTP(rec, req)
+		body, _ := io.ReadAll(rec.Body)
+		return rec.Code, string(body)
+	}
+
+	code, body := scrape(func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret-a") })
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200 for the tenant, got %d", code)
+	}
+	for _, cgroup := range []string{`cgroup="a.slice"`, `cgroup="a.slice.app.service"`} {
+		if !strings.Contains(body, cgroup) {
+			t.Errorf("Expected the tenant to see %s", cgroup)
+		}
+	}
+	for _, cgroup := range []string{`cgroup="root"`, `cgroup="b.slice"`, "cgroups_scraped"} {
+		if strings.Contains(body, cgroup) {
+			t.Errorf("Expected the tenant not to see %s", cgroup)
+		}
+	}
+
+	code, body = scrape(func(r *http.Request) { r.SetBasicAuth("admin", "admin") })
+	if code != http.StatusOK || !strings.Contains(body, `cgroup="b.slice"`) {
+		t.Errorf("Expected the full view for basic auth users, got status %d", code)
+	}
+
+	if code, _ := scrape(func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") }); code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for an unknown token, got %d", code)
+	}
+	if code, _ := scrape(func(r *http.Request) {}); code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without credentials, got %d", code)
+	}
+}
@@ -0,0 +1,244 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the web configuration file. Its format is that of the Prometheus
+// exporter-toolkit, so files can be shared with other exporters.
+type Config struct {
+	TLSServerConfig  TLSConfig  `yaml:"tls_server_config"`
+	HTTPServerConfig HTTPConfig `yaml:"http_server_config"`
+	// Users maps basic auth user names to bcrypt-hashed passwords
+	Users map[string]string `yaml:"basic_auth_users"`
+	// Tenants may only see the series of their own cgroups
+	Tenants []TenantConfig `yaml:"tenants"`
+
+	tenants []*Tenant
+	tokens  map[[sha256.Size]byte]*Tenant
+}
+
+// TLSConfig configures TLS and client certificate authentication
+type TLSConfig struct {
+	CertFile                 string        `yaml:"cert_file"`
+	KeyFile                  string        `yaml:"key_file"`
+	ClientAuth               string        `yaml:"client_auth_type"`
+	ClientCAs                string        `yaml:"client_ca_file"`
+	CipherSuites             []CipherSuite `yaml:"cipher_suites"`
+	CurvePreferences         []Curve       `yaml:"curve_preferences"`
+	MinVersion               TLSVersion    `yaml:"min_version"`
+	MaxVersion               TLSVersion    `yaml:"max_version"`
+	PreferServerCipherSuites bool          `yaml:"prefer_server_cipher_suites"`
+}
+
+// HTTPConfig configures the HTTP server
+type HTTPConfig struct {
+	HTTP2 bool `yaml:"http2"`
+	// Headers are added to every response
+	Headers map[string]string `yaml:"headers"`
+}
+
+// Enabled reports whether TLS is configured
+func (c *TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// files returns the files the TLS configuration is read from
+func (c *TLSConfig) files() []string {
+	var files []string
+	for _, file := range []string{c.CertFile, c.KeyFile, c.ClientCAs} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// LoadConfig reads and validates a web configuration file
+func LoadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{
+		TLSServerConfig:  TLSConfig{MinVersion: tls.VersionTLS12},
+		HTTPServerConfig: HTTPConfig{HTTP2: true},
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid web configuration %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	for user, hash := range c.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("basic_auth_users: password of user %q is not a bcrypt hash: %w", user, err)
+		}
+	}
+
+	tenants, tokens, err := compileTenants(c.Tenants)
+	if err != nil {
+		return err
+	}
+	c.tenants, c.tokens = tenants, tokens
+
+	t := &c.TLSServerConfig
+	for _, tenant := range c.Tenants {
+		if len(tenant.ClientCertSubjects) > 0 && t.ClientCAs == "" {
+			return fmt.Errorf("tenant %q: client_cert_subjects require client_ca_file", tenant.Name)
+		}
+	}
+	if !t.Enabled() {
+		if t.ClientCAs != "" || t.ClientAuth != "" {
+			return fmt.Errorf("tls_server_config: client authentication requires cert_file and key_file")
+		}
+		return nil
+	}
+	if t.CertFile == "" {
+		return fmt.Errorf("tls_server_config: missing cert_file")
+	}
+	if t.KeyFile == "" {
+		return fmt.Errorf("tls_server_config: missing key_file")
+	}
+	clientAuth, err := parseClientAuth(t.ClientAuth)
+	if err != nil {
+		return fmt.Errorf("tls_server_config: %w", err)
+	}
+	if t.ClientCAs != "" && clientAuth == tls.NoClientCert {
+		return fmt.Errorf("tls_server_config: client_ca_file is set but client_auth_type is %q", t.ClientAuth)
+	}
+	if t.ClientCAs == "" && (clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert) {
+		return fmt.Errorf("tls_server_config: client_auth_type %q requires client_ca_file", t.ClientAuth)
+	}
+	return nil
+}
+
+// tlsConfig loads the certificates and builds the TLS server configuration
+func (c *Config) tlsConfig() (*tls.Config, error) {
+	t := &c.TLSServerConfig
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		MinVersion:               uint16(t.MinVersion),
+		MaxVersion:               uint16(t.MaxVersion),
+		PreferServerCipherSuites: t.PreferServerCipherSuites,
+		NextProtos:               []string{"http/1.1"},
+	}
+	if c.HTTPServerConfig.HTTP2 {
+		config.NextProtos = []string{"h2", "http/1.1"}
+	}
+	for _, suite := range t.CipherSuites {
+		config.CipherSuites = append(config.CipherSuites, uint16(suite))
+	}
+	for _, curve := range t.CurvePreferences {
+		config.CurvePreferences = append(config.CurvePreferences, tls.CurveID(curve))
+	}
+
+	config.ClientAuth, _ = parseClientAuth(t.ClientAuth)
+	if t.ClientCAs != "" {
+		pem, err := os.ReadFile(t.ClientCAs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA %s", t.ClientCAs)
+		}
+		config.ClientCAs = pool
+	}
+	return config, nil
+}
+
+func parseClientAuth(s string) (tls.ClientAuthType, error) {
+	switch s {
+	case "", "NoClientCert":
+		return tls.NoClientCert, nil
+	case "RequestClientCert":
+		return tls.RequestClientCert, nil
+	case "RequireAnyClientCert", "RequireClientCert":
+		return tls.RequireAnyClientCert, nil
+	case "VerifyClientCertIfGiven":
+		return tls.VerifyClientCertIfGiven, nil
+	case "RequireAndVerifyClientCert":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("invalid client_auth_type %q", s)
+	}
+}
+
+// TLSVersion is a TLS version given by name, e.g. TLS12
+type TLSVersion uint16
+
+var tlsVersions = map[string]TLSVersion{
+	"TLS13": tls.VersionTLS13,
+	"TLS12": tls.VersionTLS12,
+	"TLS11": tls.VersionTLS11,
+	"TLS10": tls.VersionTLS10,
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (v *TLSVersion) UnmarshalYAML(node *yaml.Node) error {
+	version, ok := tlsVersions[node.Value]
+	if !ok {
+		return fmt.Errorf("unknown TLS version %q", node.Value)
+	}
+	*v = version
+	return nil
+}
+
+// CipherSuite is a TLS cipher suite given by its Go name, e.g.
+// TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+type CipherSuite uint16
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (c *CipherSuite) UnmarshalYAML(node *yaml.Node) error {
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if suite.Name == node.Value {
+			*c = CipherSuite(suite.ID)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown cipher suite %q", node.Value)
+}
+
+// Curve is an elliptic curve given by name, e.g. X25519
+type Curve tls.CurveID
+
+var curves = map[string]Curve{
+	"CurveP256": Curve(tls.CurveP256),
+	"CurveP384": Curve(tls.CurveP384),
+	"CurveP521": Curve(tls.CurveP521),
+	"X25519":    Curve(tls.X25519),
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (c *Curve) UnmarshalYAML(node *yaml.Node) error {
+	curve, ok := curves[node.Value]
+	if !ok {
+		return fmt.Errorf("unknown curve %q", node.Value)
+	}
+	*c = curve
+	return nil
+}